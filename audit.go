@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// auditRecord describes a change of branch protection, its hash covers the record and the hash of the previous one.
+type auditRecord struct {
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Repository string          `json:"repository"`
+	Branch     string          `json:"branch"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Previous   string          `json:"previous"`
+	Hash       string          `json:"hash"`
+}
+
+func (r auditRecord) digest() (string, error) {
+	r.Hash = ""
+	content, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditLog appends records to a JSONL file, each record being chained to the previous one.
+type auditLog struct {
+	mu    sync.Mutex
+	path  string
+	actor string
+}
+
+const (
+	auditLockTimeout = 30 * time.Second
+	auditLockStale   = 5 * time.Minute
+)
+
+func defaultAuditLog() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "protector", "audit.jsonl")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "state", "protector", "audit.jsonl")
+}
+
+// record appends a change to the log, it does nothing when there is no log. The log is locked while its last
+// hash is read and the record appended, so that concurrent runs don't fork the chain.
+func (al *auditLog) record(action, repo, branch string, before, after interface{}) error {
+	if al == nil {
+		return nil
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(al.path), 0700); err != nil {
+		return err
+	}
+	unlock, err := lockFile(al.path+".lock", auditLockTimeout, auditLockStale)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	last, err := lastAuditHash(al.path)
+	if err != nil {
+		return err
+	}
+	r := auditRecord{
+		Time:       time.Now().UTC(),
+		Actor:      al.actor,
+		Action:     action,
+		Repository: repo,
+		Branch:     branch,
+		Previous:   last,
+	}
+	if r.Before, err = json.Marshal(before); err != nil {
+		return err
+	}
+	if r.After, err = json.Marshal(after); err != nil {
+		return err
+	}
+	if r.Hash, err = r.digest(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lockFile creates the lock file exclusively, waiting up to timeout while another process holds it. A lock
+// older than stale was left by a process that crashed, it is taken over.
+func lockFile(path string, timeout, stale time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > stale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another process", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// lastAuditHash reads the hash of the last record of a log, the log is verified before any change is made.
+func lastAuditHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("Can't read audit log %s: %v", path, err)
+	}
+	if last == nil {
+		return "", nil
+	}
+	var record auditRecord
+	if err := json.Unmarshal(last, &record); err != nil {
+		return "", fmt.Errorf("%s: invalid last record: %v", path, err)
+	}
+	return record.Hash, nil
+}
+
+// verifyAuditLog checks the hash chain of a log and returns the hash of its last record with the number of records.
+// The chain can't tell when the last records were removed, a head hash kept outside of the log can: when anchor is
+// set, the log must still contain the record with this hash.
+func verifyAuditLog(path, anchor string) (string, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return verifyAuditRecords(f, path, anchor)
+}
+
+func verifyAuditRecords(r io.Reader, name, anchor string) (string, int, error) {
+	last := ""
+	count := 0
+	anchored := anchor == ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", count, fmt.Errorf("%s:%d: invalid record: %v", name, line, err)
+		}
+		if record.Previous != last {
+			return "", count, fmt.Errorf("%s:%d: record is not chained to the previous one, records were removed or reordered", name, line)
+		}
+		hash, err := record.digest()
+		if err != nil {
+			return "", count, err
+		}
+		if hash != record.Hash {
+			return "", count, fmt.Errorf("%s:%d: record hash doesn't match its content, record was modified", name, line)
+		}
+		last = record.Hash
+		anchored = anchored || record.Hash == anchor
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return "", count, fmt.Errorf("Can't read audit log %s: %v", name, err)
+	}
+	if !anchored {
+		return "", count, fmt.Errorf("%s: record %s is missing, records were removed from the end", name, anchor)
+	}
+	return last, count, nil
+}
+
+// auditedService records every successful protection change with the protection the branch had before.
+type auditedService struct {
+	repositoriesService
+	log *auditLog
+}
+
+// newAuditedService audits a service, with the protection settings when the service supports them.
+func newAuditedService(service repositoriesService, log *auditLog) repositoriesService {
+	audited := &auditedService{repositoriesService: service, log: log}
+	if settings, ok := service.(settingsService); ok {
+		return &auditedSettingsService{audited, settings}
+	}
+	return audited
+}
+
+func (as *auditedService) before(ctx context.Context, owner, repo, branch string) *github.Protection {
+	protection, _, err := as.repositoriesService.GetBranchProtection(ctx, owner, repo, branch)
+	if err != nil {
+		return nil
+	}
+	return protection
+}
+
+func (as *auditedService) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
+	before := as.before(ctx, owner, repo, branch)
+	protection, resp, err := as.repositoriesService.UpdateBranchProtection(ctx, owner, repo, branch, preq)
+	if err != nil {
+		return protection, resp, err
+	}
+	if err := as.log.record("update", owner+"/"+repo, branch, before, preq); err != nil {
+		return protection, resp, fmt.Errorf("protection has been updated but can't be audited: %v", err)
+	}
+	return protection, resp, nil
+}
+
+func (as *auditedService) RemoveBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Response, error) {
+	before := as.before(ctx, owner, repo, branch)
+	resp, err := as.repositoriesService.RemoveBranchProtection(ctx, owner, repo, branch)
+	if err != nil {
+		return resp, err
+	}
+	if err := as.log.record("remove", owner+"/"+repo, branch, before, nil); err != nil {
+		return resp, fmt.Errorf("protection has been removed but can't be audited: %v", err)
+	}
+	return resp, nil
+}
+
+// auditedSettingsService also records the settings missing from the vendored types.
+type auditedSettingsService struct {
+	*auditedService
+	settings settingsService
+}
+
+// protectionState is a protection, or a protection request, with its settings.
+type protectionState struct {
+	Protection interface{}         `json:"protection"`
+	Settings   *protectionSettings `json:"settings"`
+}
+
+func (as *auditedSettingsService) GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error) {
+	return as.settings.GetBranchProtectionSettings(ctx, owner, repo, branch)
+}
+
+func (as *auditedSettingsService) UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error) {
+	var before *protectionState
+	if protection, current, _, err := as.settings.GetBranchProtectionSettings(ctx, owner, repo, branch); err == nil {
+		before = &protectionState{protection, current}
+	}
+	protection, resp, err := as.settings.UpdateBranchProtectionSettings(ctx, owner, repo, branch, preq, settings)
+	_, partial := err.(*signaturesError)
+	if err != nil && !partial {
+		return protection, resp, err
+	}
+
+	applied := *settings
+	if partial {
+		applied.RequiredSignatures = before != nil && before.Settings != nil && before.Settings.RequiredSignatures
+	}
+	if err := as.log.record("update", owner+"/"+repo, branch, before, &protectionState{preq, &applied}); err != nil {
+		return protection, resp, fmt.Errorf("protection has been updated but can't be audited: %v", err)
+	}
+	return protection, resp, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAuditLogDetectsTampering(t *testing.T) {
+	// Given
+	dir, err := ioutil.TempDir("", "protector-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	service := &auditedService{
+		repositoriesService: &TestLeaseMock{protected: map[string]bool{}},
+		log:                 &auditLog{path: path, actor: "jcgay"},
+	}
+	ctx := context.TODO()
+	if _, _, err := service.UpdateBranchProtection(ctx, "jcgay", "maven-color", "master", (*policy)(nil).request()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.RemoveBranchProtection(ctx, "jcgay", "maven-color", "master"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := service.UpdateBranchProtection(ctx, "jcgay", "protector", "master", (*policy)(nil).request()); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(path)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(content), "\n"), "\n")
+	head, _, err := verifyAuditLog(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		content  string
+		anchor   string
+		expected string
+	}{
+		{"intact", string(content), head, ""},
+		{"modified", strings.Replace(string(content), `"actor":"jcgay"`, `"actor":"someone"`, 1), "", "audit.jsonl:1: record hash doesn't match"},
+		{"removed", lines[0] + lines[2], "", "audit.jsonl:2: record is not chained"},
+		{"truncated", lines[0] + lines[1], head, "audit.jsonl: record " + head + " is missing"},
+	} {
+		// When
+		_, count, err := verifyAuditRecords(strings.NewReader(test.content), "audit.jsonl", test.anchor)
+
+		// Then
+		switch {
+		case test.expected == "" && (err != nil || count != 3):
+			t.Errorf("%s log should have 3 valid records, got %d and %v", test.name, count, err)
+		case test.expected != "" && (err == nil || !strings.HasPrefix(err.Error(), test.expected)):
+			t.Errorf("%s log should be reported with [%s], got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestConcurrentAuditLogsKeepOneChain(t *testing.T) {
+	// Given
+	dir, err := ioutil.TempDir("", "protector-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.jsonl")
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+
+	// When
+	for i := 0; i < 2; i++ {
+		log := &auditLog{path: path, actor: "jcgay"}
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(log *auditLog) {
+				defer wg.Done()
+				errs <- log.record("update", "jcgay/maven-color", "master", nil, nil)
+			}(log)
+		}
+	}
+	wg.Wait()
+	close(errs)
+
+	// Then
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, count, err := verifyAuditLog(path, ""); err != nil || count != 20 {
+		t.Errorf("Log written concurrently should have 20 chained records, got %d and %v", count, err)
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	bitbucketReadOnly        = "read-only"
+	bitbucketNoDeletes       = "no-deletes"
+	bitbucketFastForwardOnly = "fast-forward-only"
+	bitbucketPullRequestOnly = "pull-request-only"
+)
+
+type bitbucketRepository struct {
+	ID      int    `json:"id"`
+	Slug    string `json:"slug"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+}
+
+func (r *bitbucketRepository) toGitHub(admin bool) *github.Repository {
+	id, name, fullName, owner := r.ID, r.Slug, r.Project.Key+"/"+r.Slug, r.Project.Key
+	return &github.Repository{
+		ID:       &id,
+		Name:     &name,
+		FullName: &fullName,
+		Owner:    &github.User{Login: &owner},
+		Permissions: &map[string]bool{
+			"admin": admin,
+		},
+	}
+}
+
+type bitbucketRestriction struct {
+	ID      int    `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Matcher struct {
+		ID        string `json:"id"`
+		DisplayID string `json:"displayId"`
+		Type      struct {
+			ID string `json:"id"`
+		} `json:"type"`
+		Active bool `json:"active"`
+	} `json:"matcher"`
+	Users  []bitbucketUser `json:"users"`
+	Groups []string        `json:"groups"`
+}
+
+type bitbucketUser struct {
+	Name string `json:"name"`
+}
+
+type bitbucketPage struct {
+	IsLastPage    bool `json:"isLastPage"`
+	NextPageStart int  `json:"nextPageStart"`
+}
+
+// bitbucketProvider maps the policy onto Bitbucket Server (Data Center) branch permissions.
+// Projects are used as organizations, repositories are named PROJECT/slug.
+type bitbucketProvider struct {
+	client        *restClient
+	orgs          []string
+	selectedRepos []string
+}
+
+func newBitbucketProvider(httpClient *http.Client, baseURL string) (*bitbucketProvider, error) {
+	if baseURL == "" {
+		return nil, errors.New("Bitbucket needs the -url of the server (ex: https://bitbucket.example.com)")
+	}
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bitbucketProvider{
+		client:        &restClient{httpClient: httpClient, baseURL: u},
+		orgs:          orgs,
+		selectedRepos: protectRepositories,
+	}, nil
+}
+
+func (bp *bitbucketProvider) repositories() repositories {
+	return bp
+}
+
+func (bp *bitbucketProvider) service() repositoriesService {
+	return bp
+}
+
+func (bp *bitbucketProvider) apiURL() *url.URL {
+	return bp.client.baseURL
+}
+
+// identity reads the user name Bitbucket Server sends back with every authenticated response.
+func (bp *bitbucketProvider) identity() (string, error) {
+	resp, err := bp.client.do(context.TODO(), "GET", "rest/api/1.0/application-properties", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if user := resp.Header.Get("X-AUSERNAME"); user != "" {
+		return user, nil
+	}
+	return "", errors.New("Bitbucket Server didn't send the user name")
+}
+
+// each walks a paginated Bitbucket collection, decoding values of every page with decode.
+func (bp *bitbucketProvider) each(ctx context.Context, path string, decode func() (interface{}, *bitbucketPage, func())) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	start := 0
+	for {
+		page, info, done := decode()
+		if _, err := bp.client.do(ctx, "GET", fmt.Sprintf("%s%sstart=%d&limit=100", path, separator, start), nil, page); err != nil {
+			return err
+		}
+		done()
+		if info.IsLastPage {
+			return nil
+		}
+		start = info.NextPageStart
+	}
+}
+
+func (bp *bitbucketProvider) listRepositories(ctx context.Context, query string) ([]*bitbucketRepository, error) {
+	repos := make([]*bitbucketRepository, 0)
+	err := bp.each(ctx, "rest/api/1.0/repos?"+query, func() (interface{}, *bitbucketPage, func()) {
+		var page struct {
+			bitbucketPage
+			Values []*bitbucketRepository `json:"values"`
+		}
+		return &page, &page.bitbucketPage, func() { repos = append(repos, page.Values...) }
+	})
+	return repos, err
+}
+
+// listProject lists the repositories of a project, the ones administered with the token are looked up separately
+// because Bitbucket doesn't return permissions with repositories.
+func (bp *bitbucketProvider) listProject(ctx context.Context, project string, result chan *github.Repository) error {
+	query := ""
+	if project != "" {
+		query = "projectkey=" + url.QueryEscape(project)
+	}
+	all, err := bp.listRepositories(ctx, query)
+	if err != nil {
+		return err
+	}
+	adminQuery := "permission=REPO_ADMIN"
+	if query != "" {
+		adminQuery = query + "&" + adminQuery
+	}
+	administered, err := bp.listRepositories(ctx, adminQuery)
+	if err != nil {
+		return err
+	}
+
+	admin := make(map[int]bool)
+	for _, repo := range administered {
+		admin[repo.ID] = true
+	}
+	for _, repo := range all {
+		result <- repo.toGitHub(admin[repo.ID])
+	}
+	return nil
+}
+
+func (bp *bitbucketProvider) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, 20)
+	var wg sync.WaitGroup
+
+	switch {
+	case len(bp.selectedRepos) > 0:
+		for _, name := range bp.selectedRepos {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				metas := strings.SplitN(name, "/", 2)
+				repos, err := bp.listRepositories(context.TODO(), "projectkey="+url.QueryEscape(metas[0])+"&name="+url.QueryEscape(metas[1])+"&permission=REPO_ADMIN")
+				if err != nil {
+					unresolved(newUnresolvedResult(name, err))
+					return
+				}
+				admin := false
+				for _, repo := range repos {
+					admin = admin || repo.Slug == metas[1]
+				}
+				repo := new(bitbucketRepository)
+				if _, err := bp.client.do(context.TODO(), "GET", bitbucketRepoPath("api/1.0", metas[0], metas[1]), nil, repo); err != nil {
+					unresolved(newUnresolvedResult(name, err))
+					return
+				}
+				result <- repo.toGitHub(admin)
+			}(name)
+		}
+	case len(bp.orgs) > 0:
+		for _, project := range bp.orgs {
+			wg.Add(1)
+			go func(project string) {
+				defer wg.Done()
+				if err := bp.listProject(context.TODO(), project, result); err != nil {
+					unresolved(newUnresolvedResult(project, err))
+				}
+			}(project)
+		}
+	default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bp.listProject(context.TODO(), "", result); err != nil {
+				unresolved(newUnresolvedResult("user", err))
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(result)
+	}()
+	return result
+}
+
+func bitbucketRepoPath(api, project, slug string) string {
+	return fmt.Sprintf("rest/%s/projects/%s/repos/%s", api, url.PathEscape(project), url.PathEscape(slug))
+}
+
+// restrictions lists branch permissions of a repository, only the ones targeting branch when not empty.
+func (bp *bitbucketProvider) restrictions(ctx context.Context, project, slug, branch string) ([]*bitbucketRestriction, error) {
+	path := bitbucketRepoPath("branch-permissions/2.0", project, slug) + "/restrictions"
+	if branch != "" {
+		path += "?matcherType=BRANCH&matcherId=" + url.QueryEscape("refs/heads/"+branch)
+	}
+
+	restrictions := make([]*bitbucketRestriction, 0)
+	err := bp.each(ctx, path, func() (interface{}, *bitbucketPage, func()) {
+		var page struct {
+			bitbucketPage
+			Values []*bitbucketRestriction `json:"values"`
+		}
+		return &page, &page.bitbucketPage, func() { restrictions = append(restrictions, page.Values...) }
+	})
+	return restrictions, err
+}
+
+func (bp *bitbucketProvider) ListBranches(ctx context.Context, owner string, repo string, opt *github.ListOptions) ([]*github.Branch, *github.Response, error) {
+	restrictions, err := bp.restrictions(ctx, owner, repo, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	protected := make(map[string]bool)
+	for _, restriction := range restrictions {
+		if restriction.Matcher.Type.ID == "BRANCH" {
+			protected[restriction.Matcher.ID] = true
+		}
+	}
+
+	branches := make([]*github.Branch, 0)
+	err = bp.each(ctx, bitbucketRepoPath("api/1.0", owner, repo)+"/branches", func() (interface{}, *bitbucketPage, func()) {
+		var page struct {
+			bitbucketPage
+			Values []struct {
+				ID        string `json:"id"`
+				DisplayID string `json:"displayId"`
+			} `json:"values"`
+		}
+		return &page, &page.bitbucketPage, func() {
+			for _, value := range page.Values {
+				name, isProtected := value.DisplayID, protected[value.ID]
+				branches = append(branches, &github.Branch{Name: &name, Protected: &isProtected})
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return branches, &github.Response{Response: &http.Response{StatusCode: http.StatusOK, Status: "200 OK"}}, nil
+}
+
+func (bp *bitbucketProvider) GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error) {
+	restrictions, err := bp.restrictions(ctx, owner, repo, branchName)
+	if err != nil {
+		return nil, nil, err
+	}
+	protected := len(restrictions) > 0
+	return &github.Branch{Name: &branchName, Protected: &protected}, nil, nil
+}
+
+// GetBranchProtection describes the branch permissions with their GitHub equivalent: pull-request-only requires
+// reviews and read-only restricts pushes to its exempted users and groups.
+func (bp *bitbucketProvider) GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error) {
+	protection, _, resp, err := bp.GetBranchProtectionSettings(ctx, owner, repo, branch)
+	return protection, resp, err
+}
+
+// GetBranchProtectionSettings also reads the settings set by branch permissions: fast-forward-only prevents
+// force pushes and no-deletes prevents deletions.
+func (bp *bitbucketProvider) GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error) {
+	restrictions, err := bp.restrictions(ctx, owner, repo, branch)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	protection := &github.Protection{EnforceAdmins: &github.AdminEnforcement{Enabled: true}}
+	settings := &protectionSettings{AllowForcePushes: true, AllowDeletions: true}
+	for _, restriction := range restrictions {
+		switch restriction.Type {
+		case bitbucketPullRequestOnly:
+			protection.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcement{}
+		case bitbucketReadOnly:
+			protection.Restrictions = &github.BranchRestrictions{}
+			for _, user := range restriction.Users {
+				login := user.Name
+				protection.Restrictions.Users = append(protection.Restrictions.Users, &github.User{Login: &login})
+			}
+			for _, group := range restriction.Groups {
+				slug := group
+				protection.Restrictions.Teams = append(protection.Restrictions.Teams, &github.Team{Slug: &slug})
+			}
+		case bitbucketFastForwardOnly:
+			settings.AllowForcePushes = false
+		case bitbucketNoDeletes:
+			settings.AllowDeletions = false
+		}
+	}
+	return protection, settings, nil, nil
+}
+
+// UpdateBranchProtection sets the branch permissions of a branch, it can't then be deleted or rewritten.
+func (bp *bitbucketProvider) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
+	return bp.UpdateBranchProtectionSettings(ctx, owner, repo, branch, preq, &protectionSettings{})
+}
+
+// UpdateBranchProtectionSettings changes the branch permissions of a branch to the expected ones. The missing
+// restrictions are added before the extra ones are deleted, so the branch is never left unprotected.
+func (bp *bitbucketProvider) UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error) {
+	current, err := bp.restrictions(ctx, owner, repo, branch)
+	if err != nil {
+		return nil, nil, err
+	}
+	expected := bitbucketRestrictions(branch, preq, settings)
+
+	path := bitbucketRepoPath("branch-permissions/2.0", owner, repo) + "/restrictions"
+	for _, restriction := range expected {
+		if restriction.in(current) {
+			continue
+		}
+		if resp, err := bp.client.do(ctx, "POST", path, restriction, nil); err != nil {
+			return nil, restResponse(resp), err
+		}
+	}
+	for _, restriction := range current {
+		if restriction.in(expected) {
+			continue
+		}
+		if resp, err := bp.client.do(ctx, "DELETE", fmt.Sprintf("%s/%d", path, restriction.ID), nil, nil); err != nil {
+			return nil, restResponse(resp), err
+		}
+	}
+	return nil, nil, nil
+}
+
+// bitbucketRestrictions maps a protection request onto branch permissions: pull-request-only is expected when
+// reviews are required, read-only when pushes are restricted, fast-forward-only and no-deletes unless the
+// settings allow force pushes and deletions.
+func bitbucketRestrictions(branch string, preq *github.ProtectionRequest, settings *protectionSettings) []*bitbucketRestriction {
+	types := make([]string, 0)
+	if !settings.AllowDeletions {
+		types = append(types, bitbucketNoDeletes)
+	}
+	if !settings.AllowForcePushes {
+		types = append(types, bitbucketFastForwardOnly)
+	}
+	if preq.RequiredPullRequestReviews != nil {
+		types = append(types, bitbucketPullRequestOnly)
+	}
+	if preq.Restrictions != nil {
+		types = append(types, bitbucketReadOnly)
+	}
+
+	restrictions := make([]*bitbucketRestriction, 0, len(types))
+	for _, restrictionType := range types {
+		restriction := &bitbucketRestriction{Type: restrictionType, Users: []bitbucketUser{}, Groups: []string{}}
+		restriction.Matcher.ID = "refs/heads/" + branch
+		restriction.Matcher.DisplayID = branch
+		restriction.Matcher.Type.ID = "BRANCH"
+		restriction.Matcher.Active = true
+		if restrictionType == bitbucketReadOnly {
+			for _, user := range preq.Restrictions.Users {
+				restriction.Users = append(restriction.Users, bitbucketUser{Name: user})
+			}
+			restriction.Groups = nonNil(preq.Restrictions.Teams)
+		}
+		restrictions = append(restrictions, restriction)
+	}
+	return restrictions
+}
+
+// in tells if an equivalent restriction, of the same type and exempting the same users and groups, is in restrictions.
+func (r *bitbucketRestriction) in(restrictions []*bitbucketRestriction) bool {
+	users := make([]string, 0, len(r.Users))
+	for _, user := range r.Users {
+		users = append(users, user.Name)
+	}
+	for _, other := range restrictions {
+		if other.Type != r.Type {
+			continue
+		}
+		otherUsers := make([]string, 0, len(other.Users))
+		for _, user := range other.Users {
+			otherUsers = append(otherUsers, user.Name)
+		}
+		if len(missing(users, otherUsers)) == 0 && len(missing(otherUsers, users)) == 0 &&
+			len(missing(r.Groups, other.Groups)) == 0 && len(missing(other.Groups, r.Groups)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (bp *bitbucketProvider) RemoveBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Response, error) {
+	restrictions, err := bp.restrictions(ctx, owner, repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	for _, restriction := range restrictions {
+		path := fmt.Sprintf("%s/restrictions/%d", bitbucketRepoPath("branch-permissions/2.0", owner, repo), restriction.ID)
+		if resp, err := bp.client.do(ctx, "DELETE", path, nil, nil); err != nil {
+			return restResponse(resp), err
+		}
+	}
+	return nil, nil
+}
+
+// unsupported lists the policy settings that Bitbucket branch permissions can't enforce.
+func (bp *bitbucketProvider) unsupported(p *policy) []string {
+	warnings := githubOnlySettings(p, "allow_force_pushes", "allow_deletions")
+	if p != nil && p.RequiredStatusChecks != nil {
+		warnings = append(warnings, "required_status_checks: builds are required with merge checks, not branch permissions")
+	}
+	if p != nil && p.RequiredPullRequestReviews != nil && p.RequiredPullRequestReviews.DismissStaleReviews {
+		warnings = append(warnings, "dismiss_stale_reviews: approvals are reset by the repository pull request settings, not branch permissions")
+	}
+	return warnings
+}
+
+// supported removes from a policy the settings that branch permissions can't enforce, they are reported once
+// by unsupported instead of on every branch.
+func (bp *bitbucketProvider) supported(p *policy) *policy {
+	if p == nil {
+		return p
+	}
+	enforced := *p
+	enforced.RequiredStatusChecks = nil
+	enforced.RequiredLinearHistory = false
+	enforced.RequiredSignatures = false
+	if p.RequiredPullRequestReviews != nil {
+		enforced.RequiredPullRequestReviews = &reviewsPolicy{}
+	}
+	return &enforced
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestBitbucketProviderCreatesBranchPermissions(t *testing.T) {
+	// Given
+	created := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/api/1.0/repos":
+			fmt.Fprint(w, `{"isLastPage": true, "values": [{"id": 1, "slug": "api", "project": {"key": "PLAT"}}]}`)
+		case r.Method == "GET" && r.URL.Path == "/rest/branch-permissions/2.0/projects/PLAT/repos/api/restrictions":
+			fmt.Fprint(w, `{"isLastPage": true, "values": []}`)
+		case r.Method == "GET" && r.URL.Path == "/rest/api/1.0/projects/PLAT/repos/api/branches":
+			fmt.Fprint(w, `{"isLastPage": true, "values": [{"id": "refs/heads/master", "displayId": "master"}]}`)
+		case r.Method == "POST" && r.URL.Path == "/rest/branch-permissions/2.0/projects/PLAT/repos/api/restrictions":
+			var restriction bitbucketRestriction
+			json.NewDecoder(r.Body).Decode(&restriction)
+			created = append(created, restriction.Type+" "+restriction.Matcher.ID)
+			fmt.Fprint(w, `{}`)
+		default:
+			http.Error(w, `{"errors": []}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := newBitbucketProvider(http.DefaultClient, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.orgs = []string{"PLAT"}
+
+	success := new(bytes.Buffer)
+	failure := new(bytes.Buffer)
+	gp := &githubProtection{
+		repositoriesService: provider.service(),
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^master$")},
+		policy:              &policy{RequiredPullRequestReviews: &reviewsPolicy{}},
+		successOutput:       success,
+		failureOutput:       failure,
+	}
+
+	// When
+	for repo := range provider.repositories().fetch(func(*result) {}) {
+		gp.protect(repo)
+	}
+
+	// Then
+	if failure.String() != "" || success.String() != "PLAT/api: master is now protected\n" {
+		t.Errorf("master should be protected, got: [%s] [%s]", success.String(), failure.String())
+	}
+	sort.Strings(created)
+	expected := []string{"fast-forward-only refs/heads/master", "no-deletes refs/heads/master", "pull-request-only refs/heads/master"}
+	if fmt.Sprint(created) != fmt.Sprint(expected) {
+		t.Errorf("Expected restrictions %v, got: %v", expected, created)
+	}
+}
+
+func TestBitbucketProviderUpdatesChangedBranchPermissions(t *testing.T) {
+	// Given
+	calls := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/branch-permissions/2.0/projects/PLAT/repos/api/restrictions":
+			fmt.Fprint(w, `{"isLastPage": true, "values": [
+  {"id": 1, "type": "no-deletes", "matcher": {"id": "refs/heads/master"}, "users": [], "groups": []},
+  {"id": 2, "type": "fast-forward-only", "matcher": {"id": "refs/heads/master"}, "users": [], "groups": []},
+  {"id": 3, "type": "read-only", "matcher": {"id": "refs/heads/master"}, "users": [{"name": "bob"}], "groups": []}
+]}`)
+		case r.Method == "POST":
+			var restriction bitbucketRestriction
+			json.NewDecoder(r.Body).Decode(&restriction)
+			calls = append(calls, "POST "+restriction.Type)
+			fmt.Fprint(w, `{}`)
+		case r.Method == "DELETE":
+			calls = append(calls, "DELETE "+r.URL.Path)
+		default:
+			http.Error(w, `{"errors": []}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := newBitbucketProvider(http.DefaultClient, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preq := &github.ProtectionRequest{RequiredPullRequestReviews: &github.PullRequestReviewsEnforcementRequest{}}
+
+	// When
+	_, _, err = provider.UpdateBranchProtectionSettings(context.TODO(), "PLAT", "api", "master", preq, &protectionSettings{AllowForcePushes: true})
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"POST pull-request-only",
+		"DELETE /rest/branch-permissions/2.0/projects/PLAT/repos/api/restrictions/2",
+		"DELETE /rest/branch-permissions/2.0/projects/PLAT/repos/api/restrictions/3",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v", expected, calls)
+	}
+}
+
+func TestBitbucketProviderIgnoresStaleReviewsDismissal(t *testing.T) {
+	// Given
+	provider := &bitbucketProvider{}
+	p := &policy{RequiredPullRequestReviews: &reviewsPolicy{DismissStaleReviews: true}}
+
+	// When
+	supported := provider.supported(p)
+
+	// Then
+	if supported.RequiredPullRequestReviews == nil || supported.RequiredPullRequestReviews.DismissStaleReviews {
+		t.Errorf("Only stale reviews dismissal should be removed, got: %+v", supported.RequiredPullRequestReviews)
+	}
+	if len(provider.unsupported(p)) != 1 {
+		t.Errorf("Stale reviews dismissal should be reported as unsupported, got: %v", provider.unsupported(p))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheTransport stores GitHub responses on disk and revalidates them with conditional requests.
+// GitHub doesn't count 304 responses against the rate limit.
+type cacheTransport struct {
+	base   http.RoundTripper
+	dir    string
+	maxAge time.Duration
+}
+
+type cacheEntry struct {
+	Stored       time.Time `json:"stored"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Response     []byte    `json:"response"`
+}
+
+func (ct *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return ct.base.RoundTrip(req)
+	}
+
+	path := ct.path(req)
+	entry := ct.load(path)
+	if entry != nil {
+		req = cloneRequest(req)
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := ct.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		cached, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(entry.Response)), req)
+		if err != nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+		for _, header := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Date"} {
+			if value := resp.Header.Get(header); value != "" {
+				cached.Header.Set(header, value)
+			}
+		}
+		entry.Stored = time.Now()
+		ct.save(path, entry)
+		return cached, nil
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode == http.StatusOK && (etag != "" || lastModified != "") {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		dump := new(bytes.Buffer)
+		stored := *resp
+		stored.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err := stored.Write(dump); err == nil {
+			ct.save(path, &cacheEntry{
+				Stored:       time.Now(),
+				ETag:         etag,
+				LastModified: lastModified,
+				Response:     dump.Bytes(),
+			})
+		}
+	}
+
+	return resp, nil
+}
+
+// path identifies a request by its URL and the headers changing the response, including the token,
+// so that different tokens never share cached responses.
+func (ct *cacheTransport) path(req *http.Request) string {
+	hash := sha256.New()
+	for _, part := range []string{req.URL.String(), req.Header.Get("Accept"), req.Header.Get("Authorization")} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return filepath.Join(ct.dir, hex.EncodeToString(hash.Sum(nil)))
+}
+
+func (ct *cacheTransport) load(path string) *cacheEntry {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(content, entry); err != nil {
+		return nil
+	}
+	if ct.maxAge > 0 && time.Since(entry.Stored) > ct.maxAge {
+		os.Remove(path)
+		return nil
+	}
+	return entry
+}
+
+func (ct *cacheTransport) save(path string, entry *cacheEntry) {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(ct.dir, 0700); err != nil {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(ct.dir, "entry")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(content)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	os.Rename(tmp.Name(), path)
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+func defaultCacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "protector")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache", "protector")
+}
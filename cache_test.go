@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCacheSendsConditionalRequests(t *testing.T) {
+	// Given
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `[{"name":"master"}]`)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "protector-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := &http.Client{Transport: &cacheTransport{base: http.DefaultTransport, dir: dir, maxAge: time.Hour}}
+
+	// When
+	bodies := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL + "/repos/jcgay/maven-color/branches")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Cached response should be seen as a 200, got: %d", resp.StatusCode)
+		}
+		bodies = append(bodies, string(body))
+	}
+
+	// Then
+	if calls != 2 || bodies[0] != bodies[1] {
+		t.Errorf("Second call should be revalidated and served from cache, got %d calls and bodies %v", calls, bodies)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"strings"
+)
+
+// codeOwnersPaths are the locations where GitHub looks for a CODEOWNERS file, in order.
+var codeOwnersPaths = []string{"CODEOWNERS", ".github/CODEOWNERS", "docs/CODEOWNERS"}
+
+// codeOwnersHost tells if an owner (@user or @org/team-slug) can write to a repository.
+type codeOwnersHost interface {
+	canWrite(repo *github.Repository, owner string) (bool, error)
+}
+
+// codeOwnerReviews tells if the policy requires code owner reviews. They are sent with the other settings missing
+// from the vendored types (see settingsRequest), this file only checks that they can be required.
+func (p *policy) codeOwnerReviews() bool {
+	return p != nil && p.RequiredPullRequestReviews != nil && p.RequiredPullRequestReviews.RequireCodeOwnerReviews
+}
+
+// withCodeOwners returns the policy to apply on a branch: code owner reviews are removed from it when they can't be
+// required, and the findings explaining why are returned so that the rest of the policy is still applied.
+func (gp *githubProtection) withCodeOwners(repo *github.Repository, branchName string, p *policy) (*policy, []finding, error) {
+	if !p.codeOwnerReviews() || gp.codeOwners == nil {
+		return p, nil, nil
+	}
+	problems, err := gp.checkCodeOwners(repo, branchName)
+	if err != nil || len(problems) == 0 {
+		return p, nil, err
+	}
+
+	copied := *p
+	reviews := *p.RequiredPullRequestReviews
+	reviews.RequireCodeOwnerReviews = false
+	copied.RequiredPullRequestReviews = &reviews
+	return &copied, problems, nil
+}
+
+// checkCodeOwners lists what prevents code owner reviews from being required on a branch:
+// a missing CODEOWNERS file, or owners that don't exist or can't write to the repository.
+func (gp *githubProtection) checkCodeOwners(repo *github.Repository, branchName string) ([]finding, error) {
+	if gp.readFile == nil {
+		return nil, nil
+	}
+
+	var content []byte
+	var path string
+	for _, candidate := range codeOwnersPaths {
+		file, err := gp.readFile(repo, branchName, candidate)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s: %v", candidate, err)
+		}
+		if file != nil {
+			content, path = file, candidate
+			break
+		}
+	}
+	if content == nil {
+		return []finding{{ruleCodeOwnersFile, "no CODEOWNERS file in the root, .github/ or docs/"}}, nil
+	}
+
+	var findings []finding
+	for _, owner := range parseCodeOwners(content) {
+		writer, err := gp.codeOwners.canWrite(repo, owner)
+		if err != nil {
+			return nil, fmt.Errorf("can't check code owner %s: %v", owner, err)
+		}
+		if !writer {
+			findings = append(findings, finding{ruleCodeOwner, fmt.Sprintf("code owner %s of %s doesn't exist or can't write to the repository", owner, path)})
+		}
+	}
+	return findings, nil
+}
+
+// parseCodeOwners lists the users and teams owning files, emails can't be checked and are ignored.
+func parseCodeOwners(content []byte) []string {
+	owners := make([]string, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, owner := range fields[1:] {
+			if strings.HasPrefix(owner, "@") && !seen[owner] {
+				seen[owner] = true
+				owners = append(owners, owner)
+			}
+		}
+	}
+	return owners
+}
+
+func (gp *githubProvider) canWrite(repo *github.Repository, owner string) (bool, error) {
+	name := strings.TrimPrefix(owner, "@")
+	if i := strings.Index(name, "/"); i >= 0 {
+		return gp.teamCanWrite(repo, name[:i], name[i+1:])
+	}
+
+	level, resp, err := gp.client.Repositories.GetPermissionLevel(context.TODO(), *repo.Owner.Login, *repo.Name, name)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	permission := level.GetPermission()
+	return permission == "admin" || permission == "write", nil
+}
+
+// teamCanWrite looks for the team in the teams having access to the repository, with push, maintain or admin permission.
+func (gp *githubProvider) teamCanWrite(repo *github.Repository, org, slug string) (bool, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := gp.client.Repositories.ListTeams(context.TODO(), *repo.Owner.Login, *repo.Name, opt)
+		if err != nil {
+			return false, err
+		}
+		for _, team := range teams {
+			if strings.EqualFold(*repo.Owner.Login, org) && strings.EqualFold(team.GetSlug(), slug) {
+				permission := team.GetPermission()
+				return permission == "admin" || permission == "maintain" || permission == "push", nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
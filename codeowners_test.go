@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type TestCodeOwnersMock struct {
+	writers map[string]bool
+}
+
+func (m *TestCodeOwnersMock) canWrite(repo *github.Repository, owner string) (bool, error) {
+	return m.writers[owner], nil
+}
+
+func codeOwnersProtection(files map[string]string, owners *TestCodeOwnersMock) (*githubProtection, *TestSettingsMock, *reportCollector) {
+	mock := &TestSettingsMock{TestLeaseMock: TestLeaseMock{protected: map[string]bool{}}}
+	collector := new(reportCollector)
+	return &githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       new(bytes.Buffer),
+		failureOutput:       new(bytes.Buffer),
+		listeners:           []listener{collector},
+		policy:              &policy{RequiredPullRequestReviews: &reviewsPolicy{RequireCodeOwnerReviews: true}},
+		codeOwners:          owners,
+		readFile: func(repo *github.Repository, ref, path string) ([]byte, error) {
+			if content, ok := files[ref+":"+path]; ok {
+				return []byte(content), nil
+			}
+			return nil, nil
+		},
+	}, mock, collector
+}
+
+func codeOwnersRepository() *github.Repository {
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	return &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+}
+
+func TestProtectDropsCodeOwnerReviewsWithoutCodeOwnersFile(t *testing.T) {
+	// Given
+	owners := &TestCodeOwnersMock{}
+	gp, mock, collector := codeOwnersProtection(map[string]string{"master:CODEOWNERS": "* @jcgay"}, owners)
+
+	// When
+	gp.protect(codeOwnersRepository())
+
+	// Then
+	results := collector.sorted()
+	if len(results) != 1 || results[0].status != statusProtected || results[0].findings[0].rule != ruleCodeOwnersFile {
+		t.Errorf("Missing CODEOWNERS on the protected branch should be a finding, got %+v", results)
+	}
+	if mock.request == nil || mock.request.RequiredPullRequestReviews == nil {
+		t.Errorf("Rest of the policy should be applied, got %+v", mock.request)
+	}
+	if mock.settings == nil || mock.settings.RequireCodeOwnerReviews {
+		t.Errorf("Code owner reviews should not be required, got %+v", mock.settings)
+	}
+}
+
+func TestProtectDropsCodeOwnerReviewsWithoutWriteAccess(t *testing.T) {
+	// Given
+	owners := &TestCodeOwnersMock{writers: map[string]bool{"@jcgay": true}}
+	codeOwners := "# owners\n* @jcgay\n/docs/ @jcgay/writers docs@example.com @ghost\n"
+	gp, mock, collector := codeOwnersProtection(map[string]string{"branche-1:.github/CODEOWNERS": codeOwners}, owners)
+
+	// When
+	gp.protect(codeOwnersRepository())
+
+	// Then
+	results := collector.sorted()
+	if len(results) != 1 || results[0].status != statusProtected {
+		t.Fatalf("Branch should be protected, got %+v", results)
+	}
+	expected := []finding{
+		{ruleCodeOwner, "code owner @jcgay/writers of .github/CODEOWNERS doesn't exist or can't write to the repository"},
+		{ruleCodeOwner, "code owner @ghost of .github/CODEOWNERS doesn't exist or can't write to the repository"},
+	}
+	if !reflect.DeepEqual(results[0].findings, expected) {
+		t.Errorf("Unexpected findings %+v", results[0].findings)
+	}
+	if mock.settings == nil || mock.settings.RequireCodeOwnerReviews {
+		t.Errorf("Code owner reviews should not be required, got %+v", mock.settings)
+	}
+}
+
+func TestProtectRequiresCodeOwnerReviews(t *testing.T) {
+	// Given
+	owners := &TestCodeOwnersMock{writers: map[string]bool{"@jcgay": true}}
+	gp, mock, collector := codeOwnersProtection(map[string]string{"branche-1:docs/CODEOWNERS": "* @jcgay"}, owners)
+
+	// When
+	gp.protect(codeOwnersRepository())
+
+	// Then
+	results := collector.sorted()
+	if len(results) != 1 || results[0].status != statusProtected {
+		t.Errorf("Branch should be protected, got %+v", results)
+	}
+	if mock.settings == nil || !mock.settings.RequireCodeOwnerReviews {
+		t.Errorf("Code owner reviews should be required with the protection, got %+v", mock.settings)
+	}
+}
+
+func TestRulesDropCodeOwnerReviewsWithoutCodeOwnersFile(t *testing.T) {
+	// Given
+	var mutations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "mutation") {
+			mutations = append(mutations, string(body))
+		}
+		fmt.Fprint(w, `{"data": {"repository": {"id": "R1", "branchProtectionRules": {"pageInfo": {"hasNextPage": false}, "nodes": []}}}}`)
+	}))
+	defer server.Close()
+	gp, _, collector := codeOwnersProtection(map[string]string{"master:CODEOWNERS": "* @jcgay"}, &TestCodeOwnersMock{})
+	rp := newRuleProtection(gp, &graphqlClient{httpClient: http.DefaultClient, endpoint: server.URL})
+
+	// When
+	rp.protect(codeOwnersRepository())
+
+	// Then
+	results := collector.sorted()
+	if len(results) != 1 || results[0].status != statusProtected || results[0].findings[0].rule != ruleCodeOwnersFile {
+		t.Errorf("Missing CODEOWNERS on the default branch should be a finding, got %+v", results)
+	}
+	if len(mutations) != 1 || !strings.Contains(mutations[0], `"requiresCodeOwnerReviews":false`) {
+		t.Errorf("Rule should be created without code owner reviews, got %v", mutations)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"sort"
+	"strings"
+)
+
+// lockWithDiscovery locks a branch, the status check contexts reported by every one of its last commits
+// are required with -apply-contexts, and only suggested otherwise.
+func (gp *githubProtection) lockWithDiscovery(repo *github.Repository, branch *github.Branch, p *policy) *result {
+	if gp.discoverCommits <= 0 || gp.commitContexts == nil {
+		return gp.lock(repo, branch, p)
+	}
+
+	commits, err := gp.commitContexts(repo, *branch.Name, gp.discoverCommits)
+	if err != nil {
+		return newResult(repo, *branch.Name, statusFailed, fmt.Sprintf("can't discover status check contexts: %v", err))
+	}
+	contexts := alwaysReported(commits)
+	if gp.applyContexts {
+		return gp.lock(repo, branch, p.withContexts(contexts))
+	}
+
+	r := gp.lock(repo, branch, p)
+	if suggested := missing(contexts, p.contexts()); len(suggested) > 0 {
+		r.message += fmt.Sprintf(", suggested status check contexts: %s", strings.Join(suggested, ", "))
+	}
+	return r
+}
+
+// verifyContexts checks that the contexts the policy adds to a branch were reported by its last commits,
+// it describes the ones that were not so that branches don't wait for checks that never run.
+func (gp *githubProtection) verifyContexts(repo *github.Repository, branchName string, p *policy, current *github.Protection) (string, error) {
+	added := p.contexts()
+	if current != nil && current.RequiredStatusChecks != nil {
+		added = missing(added, current.RequiredStatusChecks.Contexts)
+	}
+	if gp.verifyCommits <= 0 || gp.commitContexts == nil || len(added) == 0 {
+		return "", nil
+	}
+
+	commits, err := gp.commitContexts(repo, branchName, gp.verifyCommits)
+	if err != nil {
+		return "", fmt.Errorf("can't verify status check contexts: %v", err)
+	}
+	var reported []string
+	for _, contexts := range commits {
+		reported = append(reported, contexts...)
+	}
+	if unreported := missing(added, reported); len(unreported) > 0 {
+		return fmt.Sprintf("status check contexts not reported by the last %d commits: %s", gp.verifyCommits, strings.Join(unreported, ", ")), nil
+	}
+	return "", nil
+}
+
+// alwaysReported returns the contexts found in the statuses of every commit.
+func alwaysReported(commits [][]string) []string {
+	if len(commits) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int)
+	for _, contexts := range commits {
+		seen := make(map[string]bool)
+		for _, context := range contexts {
+			if !seen[context] {
+				seen[context] = true
+				counts[context]++
+			}
+		}
+	}
+
+	result := make([]string, 0)
+	for context, count := range counts {
+		if count == len(commits) {
+			result = append(result, context)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (p *policy) contexts() []string {
+	if p == nil || p.RequiredStatusChecks == nil {
+		return nil
+	}
+	return p.RequiredStatusChecks.Contexts
+}
+
+// withContexts returns a copy of the policy also requiring contexts.
+func (p *policy) withContexts(contexts []string) *policy {
+	if len(missing(contexts, p.contexts())) == 0 {
+		return p
+	}
+
+	copied := policy{}
+	if p != nil {
+		copied = *p
+	}
+	checks := statusChecksPolicy{}
+	if copied.RequiredStatusChecks != nil {
+		checks = *copied.RequiredStatusChecks
+	}
+	checks.Contexts = append(append([]string(nil), checks.Contexts...), missing(contexts, checks.Contexts)...)
+	copied.RequiredStatusChecks = &checks
+	return &copied
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+type TestDiscoveredContextsMock struct {
+	TestProtectRepositoryMock
+	request *github.ProtectionRequest
+}
+
+func (p *TestDiscoveredContextsMock) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
+	p.request = preq
+	return nil, nil, nil
+}
+
+func recentCommits(repo *github.Repository, branch string, commits int) ([][]string, error) {
+	return [][]string{
+		{"ci/travis", "coverage"},
+		{"ci/travis", "coverage", "ci/travis"},
+		{"ci/travis"},
+	}, nil
+}
+
+func TestProtectSuggestsContextsReportedByEveryCommit(t *testing.T) {
+	// Given
+	success := new(bytes.Buffer)
+	mock := &TestDiscoveredContextsMock{}
+	gp := githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       success,
+		failureOutput:       new(bytes.Buffer),
+		commitContexts:      recentCommits,
+		discoverCommits:     3,
+	}
+
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	repository := &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+
+	// When
+	gp.protect(repository)
+
+	// Then
+	if success.String() != "jcgay/maven-color: branche-1 is now protected, suggested status check contexts: ci/travis\n" {
+		t.Errorf("Contexts reported by every commit should be suggested, got: [%s]", success.String())
+	}
+	if mock.request.RequiredStatusChecks != nil {
+		t.Errorf("Suggested contexts should not be required, got %+v", mock.request.RequiredStatusChecks)
+	}
+}
+
+func TestProtectAppliesDiscoveredContexts(t *testing.T) {
+	// Given
+	mock := &TestDiscoveredContextsMock{}
+	gp := githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       new(bytes.Buffer),
+		failureOutput:       new(bytes.Buffer),
+		policy:              &policy{RequiredStatusChecks: &statusChecksPolicy{Strict: true, Contexts: []string{"ci/maven"}}},
+		commitContexts:      recentCommits,
+		discoverCommits:     3,
+		applyContexts:       true,
+	}
+
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	repository := &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+
+	// When
+	gp.protect(repository)
+
+	// Then
+	checks := mock.request.RequiredStatusChecks
+	if checks == nil || !checks.Strict || !reflect.DeepEqual(checks.Contexts, []string{"ci/maven", "ci/travis"}) {
+		t.Errorf("Discovered contexts should be added to the policy, got %+v", checks)
+	}
+	if !reflect.DeepEqual(gp.policy.RequiredStatusChecks.Contexts, []string{"ci/maven"}) {
+		t.Errorf("Policy should not be modified, got %+v", gp.policy.RequiredStatusChecks.Contexts)
+	}
+}
+
+func TestProtectWarnsAboutUnreportedContexts(t *testing.T) {
+	// Given
+	success := new(bytes.Buffer)
+	mock := &TestDiscoveredContextsMock{}
+	gp := githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       success,
+		failureOutput:       new(bytes.Buffer),
+		policy:              &policy{RequiredStatusChecks: &statusChecksPolicy{Contexts: []string{"ci/maven", "coverage"}}},
+		commitContexts:      recentCommits,
+		verifyCommits:       3,
+	}
+
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	repository := &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+
+	// When
+	gp.protect(repository)
+
+	// Then
+	if success.String() != "jcgay/maven-color: branche-1 is now protected, warning: status check contexts not reported by the last 3 commits: ci/maven\n" {
+		t.Errorf("Unreported contexts should be warned about, got: [%s]", success.String())
+	}
+	if mock.request == nil {
+		t.Error("Branch should be protected despite the warning")
+	}
+}
+
+func TestProtectDoesNotRequireUnreportedContextsInStrictMode(t *testing.T) {
+	// Given
+	failure := new(bytes.Buffer)
+	collector := new(reportCollector)
+	mock := &TestDiscoveredContextsMock{}
+	gp := githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       new(bytes.Buffer),
+		failureOutput:       failure,
+		listeners:           []listener{collector},
+		policy:              &policy{RequiredStatusChecks: &statusChecksPolicy{Contexts: []string{"ci/maven"}}},
+		commitContexts:      recentCommits,
+		verifyCommits:       3,
+		strictContexts:      true,
+	}
+
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	repository := &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+
+	// When
+	gp.protect(repository)
+
+	// Then
+	if failure.String() != "jcgay/maven-color: branche-1 status check contexts not reported by the last 3 commits: ci/maven, protection is not changed\n" {
+		t.Errorf("Unreported contexts should fail the branch, got: [%s]", failure.String())
+	}
+	if mock.request != nil {
+		t.Errorf("Branch protection should not be changed, got %+v", mock.request)
+	}
+	results := collector.sorted()
+	if len(results) != 1 || results[0].findings[len(results[0].findings)-1].rule != ruleReportedContext {
+		t.Errorf("Unreported contexts should be a finding, got %+v", results)
+	}
+}
+
+func TestCommitContextsIncludeCheckRuns(t *testing.T) {
+	// Given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/jcgay/maven-color/commits":
+			fmt.Fprint(w, `[{"sha": "abc"}]`)
+		case "/repos/jcgay/maven-color/commits/abc/status":
+			fmt.Fprint(w, `{"statuses": [{"context": "ci/travis"}, {"context": "build"}]}`)
+		case "/repos/jcgay/maven-color/commits/abc/check-runs":
+			if r.Header.Get("Accept") != mediaTypeChecksPreview {
+				http.Error(w, `{"message": "preview media type expected"}`, http.StatusUnsupportedMediaType)
+				return
+			}
+			fmt.Fprint(w, `{"total_count": 2, "check_runs": [{"name": "build"}, {"name": "lint"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	provider, err := newGitHubProvider(http.DefaultClient, server.URL, "rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, login := "maven-color", "jcgay"
+
+	// When
+	commits, err := provider.commitContexts(&github.Repository{Name: &name, Owner: &github.User{Login: &login}}, "master", 1)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(commits, [][]string{{"ci/travis", "build", "lint"}}) {
+		t.Errorf("Statuses and check runs should be reported, got %v", commits)
+	}
+}
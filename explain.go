@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io"
+)
+
+// explain prints the effective policy of a branch with the level each setting comes from,
+// then how the current protection of the branch compares with it.
+func (gp *githubProtection) explain(w io.Writer, repo *github.Repository, branch string) error {
+	central, sources, err := gp.centralPolicy(repo)
+	if err != nil {
+		return err
+	}
+	override, err := gp.repoPolicy(repo)
+	if _, ok := err.(*invalidRepoPolicyError); ok {
+		fmt.Fprintf(w, "warning: %v, it is ignored\n", err)
+	} else if err != nil {
+		return err
+	}
+	effective := central.merge(override)
+
+	selected := "not selected by -branches"
+	for _, pattern := range gp.branchPatterns {
+		if pattern.MatchString(branch) {
+			selected = "selected by " + pattern.String()
+			break
+		}
+	}
+	fmt.Fprintf(w, "%s %s (%s)\n", *repo.FullName, branch, selected)
+
+	before, err := policyValues(central)
+	if err != nil {
+		return err
+	}
+	after, err := policyValues(effective)
+	if err != nil {
+		return err
+	}
+	for _, field := range policyFields {
+		source, ok := sources[field]
+		if !ok {
+			source = "default"
+		}
+		if !bytes.Equal(before[field], after[field]) {
+			source += " + " + gp.repoPolicyPath
+		}
+		fmt.Fprintf(w, "  %s: %s (%s)\n", field, after[field], source)
+	}
+
+	protected, err := gp.isProtected(repo, &github.Branch{Name: &branch})
+	if err != nil {
+		return err
+	}
+	if !protected {
+		fmt.Fprintln(w, "branch is not protected")
+		return nil
+	}
+	_, _, findings, err := gp.inspect(repo, branch, effective)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		fmt.Fprintln(w, "branch protection complies with the policy")
+	}
+	for _, f := range findings {
+		fmt.Fprintf(w, "  [%s] %s\n", f.rule, f.message)
+	}
+	return nil
+}
+
+// policyValues returns the JSON value of every setting of a policy.
+func policyValues(p *policy) (map[string]json.RawMessage, error) {
+	if p == nil {
+		p = &policy{}
+	}
+	content, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	return values, json.Unmarshal(content, &values)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+type giteaRepository struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Permissions struct {
+		Admin bool `json:"admin"`
+	} `json:"permissions"`
+}
+
+func (r *giteaRepository) toGitHub() *github.Repository {
+	id, name, fullName, owner, defaultBranch := r.ID, r.Name, r.FullName, r.Owner.Login, r.DefaultBranch
+	return &github.Repository{
+		ID:            &id,
+		Name:          &name,
+		FullName:      &fullName,
+		DefaultBranch: &defaultBranch,
+		Owner:         &github.User{Login: &owner},
+		Permissions: &map[string]bool{
+			"admin": r.Permissions.Admin,
+		},
+	}
+}
+
+type giteaBranchProtection struct {
+	BranchName             string   `json:"branch_name,omitempty"`
+	EnablePush             bool     `json:"enable_push"`
+	EnablePushWhitelist    bool     `json:"enable_push_whitelist"`
+	PushWhitelistUsernames []string `json:"push_whitelist_usernames"`
+	PushWhitelistTeams     []string `json:"push_whitelist_teams"`
+	EnableStatusCheck      bool     `json:"enable_status_check"`
+	StatusCheckContexts    []string `json:"status_check_contexts"`
+	RequiredApprovals      int      `json:"required_approvals"`
+	DismissStaleApprovals  bool     `json:"dismiss_stale_approvals"`
+	BlockOnOutdatedBranch  bool     `json:"block_on_outdated_branch"`
+}
+
+// giteaProvider manages Gitea (and Forgejo) branch protections, -orgs selects organizations (or users).
+type giteaProvider struct {
+	client        *restClient
+	orgs          []string
+	selectedRepos []string
+}
+
+func newGiteaProvider(httpClient *http.Client, baseURL string) (*giteaProvider, error) {
+	if baseURL == "" {
+		return nil, errors.New("Gitea needs the -url of its API (ex: https://gitea.example.com/api/v1)")
+	}
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &giteaProvider{
+		client:        &restClient{httpClient: httpClient, baseURL: u, pageParam: "limit", pageSize: 50},
+		orgs:          orgs,
+		selectedRepos: protectRepositories,
+	}, nil
+}
+
+func (gp *giteaProvider) repositories() repositories {
+	return gp
+}
+
+func (gp *giteaProvider) service() repositoriesService {
+	return gp
+}
+
+func (gp *giteaProvider) apiURL() *url.URL {
+	return gp.client.baseURL
+}
+
+func (gp *giteaProvider) identity() (string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := gp.client.do(context.TODO(), "GET", "user", nil, &user); err != nil {
+		return "", err
+	}
+	return user.Login, nil
+}
+
+// unsupported lists the policy settings that Gitea can't enforce.
+func (gp *giteaProvider) unsupported(p *policy) []string {
+	warnings := githubOnlySettings(p, "required_approving_review_count")
+	if p != nil && p.EnforceAdmins {
+		warnings = append(warnings, "enforce_admins: Gitea administrators can always push to protected branches")
+	}
+	return warnings
+}
+
+// supported removes from a policy the settings that Gitea can't enforce, they are reported once by unsupported
+// instead of on every branch.
+func (gp *giteaProvider) supported(p *policy) *policy {
+	if p == nil {
+		return p
+	}
+	enforced := *p
+	enforced.EnforceAdmins = false
+	enforced.RequiredLinearHistory = false
+	enforced.RequiredSignatures = false
+	if reviews := p.RequiredPullRequestReviews; reviews != nil {
+		enforced.RequiredPullRequestReviews = &reviewsPolicy{
+			DismissStaleReviews:          reviews.DismissStaleReviews,
+			RequiredApprovingReviewCount: reviews.RequiredApprovingReviewCount,
+		}
+	}
+	return &enforced
+}
+
+func (gp *giteaProvider) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, 20)
+	var wg sync.WaitGroup
+	send := func(page interface{}) {
+		for _, repo := range *page.(*[]*giteaRepository) {
+			result <- repo.toGitHub()
+		}
+	}
+	newPage := func() interface{} { return new([]*giteaRepository) }
+
+	switch {
+	case len(gp.selectedRepos) > 0:
+		for _, name := range gp.selectedRepos {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				repo := new(giteaRepository)
+				if _, err := gp.client.do(context.TODO(), "GET", "repos/"+name, nil, repo); err != nil {
+					unresolved(newUnresolvedResult(name, err))
+					return
+				}
+				result <- repo.toGitHub()
+			}(name)
+		}
+	case len(gp.orgs) > 0:
+		for _, org := range gp.orgs {
+			wg.Add(1)
+			go func(org string) {
+				defer wg.Done()
+				err := gp.client.each(context.TODO(), "orgs/"+url.PathEscape(org)+"/repos", newPage, send)
+				if e, ok := err.(*restError); ok && e.resp.StatusCode == http.StatusNotFound {
+					err = gp.client.each(context.TODO(), "users/"+url.PathEscape(org)+"/repos", newPage, send)
+				}
+				if err != nil {
+					unresolved(newUnresolvedResult(org, err))
+				}
+			}(org)
+		}
+	default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := gp.client.each(context.TODO(), "user/repos", newPage, send); err != nil {
+				unresolved(newUnresolvedResult("user", err))
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(result)
+	}()
+	return result
+}
+
+func giteaRepoPath(owner, repo string) string {
+	return "repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+func (gp *giteaProvider) ListBranches(ctx context.Context, owner string, repo string, opt *github.ListOptions) ([]*github.Branch, *github.Response, error) {
+	branches := make([]*github.Branch, 0)
+	err := gp.client.each(ctx, giteaRepoPath(owner, repo)+"/branches", func() interface{} { return new([]*restBranch) }, func(page interface{}) {
+		for _, branch := range *page.(*[]*restBranch) {
+			branches = append(branches, branch.toGitHub())
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return branches, &github.Response{Response: &http.Response{StatusCode: http.StatusOK, Status: "200 OK"}}, nil
+}
+
+func (gp *giteaProvider) GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error) {
+	branch := new(restBranch)
+	resp, err := gp.client.do(ctx, "GET", giteaRepoPath(owner, repo)+"/branches/"+url.PathEscape(branchName), nil, branch)
+	if err != nil {
+		return nil, restResponse(resp), err
+	}
+	return branch.toGitHub(), restResponse(resp), nil
+}
+
+func (gp *giteaProvider) GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error) {
+	protection, _, resp, err := gp.GetBranchProtectionSettings(ctx, owner, repo, branch)
+	return protection, resp, err
+}
+
+// GetBranchProtectionSettings also reads the number of required approvals.
+func (gp *giteaProvider) GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error) {
+	bp := new(giteaBranchProtection)
+	resp, err := gp.client.do(ctx, "GET", giteaRepoPath(owner, repo)+"/branch_protections/"+url.PathEscape(branch), nil, bp)
+	if err != nil {
+		return nil, nil, restResponse(resp), err
+	}
+
+	protection := &github.Protection{EnforceAdmins: &github.AdminEnforcement{Enabled: false}}
+	if bp.EnableStatusCheck {
+		protection.RequiredStatusChecks = &github.RequiredStatusChecks{
+			Strict:   bp.BlockOnOutdatedBranch,
+			Contexts: bp.StatusCheckContexts,
+		}
+	}
+	if bp.RequiredApprovals > 0 {
+		protection.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcement{
+			DismissStaleReviews: bp.DismissStaleApprovals,
+		}
+	}
+	if !bp.EnablePush || bp.EnablePushWhitelist {
+		protection.Restrictions = &github.BranchRestrictions{}
+		for _, username := range bp.PushWhitelistUsernames {
+			login := username
+			protection.Restrictions.Users = append(protection.Restrictions.Users, &github.User{Login: &login})
+		}
+		for _, team := range bp.PushWhitelistTeams {
+			slug := team
+			protection.Restrictions.Teams = append(protection.Restrictions.Teams, &github.Team{Slug: &slug})
+		}
+	}
+	return protection, &protectionSettings{RequiredApprovingReviewCount: bp.RequiredApprovals}, restResponse(resp), nil
+}
+
+func (gp *giteaProvider) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
+	return gp.UpdateBranchProtectionSettings(ctx, owner, repo, branch, preq, &protectionSettings{})
+}
+
+// UpdateBranchProtectionSettings maps the GitHub protection to its Gitea equivalent: required reviews become required
+// approvals (at least one), strict status checks block outdated branches and push restrictions become a push whitelist.
+func (gp *giteaProvider) UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error) {
+	bp := &giteaBranchProtection{
+		EnablePush:             true,
+		PushWhitelistUsernames: []string{},
+		PushWhitelistTeams:     []string{},
+		StatusCheckContexts:    []string{},
+	}
+	if checks := preq.RequiredStatusChecks; checks != nil {
+		bp.EnableStatusCheck = true
+		bp.StatusCheckContexts = nonNil(checks.Contexts)
+		bp.BlockOnOutdatedBranch = checks.Strict
+	}
+	if reviews := preq.RequiredPullRequestReviews; reviews != nil {
+		bp.RequiredApprovals = 1
+		if settings.RequiredApprovingReviewCount > 1 {
+			bp.RequiredApprovals = settings.RequiredApprovingReviewCount
+		}
+		bp.DismissStaleApprovals = reviews.DismissStaleReviews
+	}
+	if restrictions := preq.Restrictions; restrictions != nil {
+		bp.EnablePushWhitelist = true
+		bp.PushWhitelistUsernames = nonNil(restrictions.Users)
+		bp.PushWhitelistTeams = nonNil(restrictions.Teams)
+	}
+
+	path := giteaRepoPath(owner, repo) + "/branch_protections"
+	resp, err := gp.client.do(ctx, "GET", path+"/"+url.PathEscape(branch), nil, nil)
+	switch {
+	case err == nil:
+		resp, err = gp.client.do(ctx, "PATCH", path+"/"+url.PathEscape(branch), bp, nil)
+	case resp != nil && resp.StatusCode == http.StatusNotFound:
+		bp.BranchName = branch
+		resp, err = gp.client.do(ctx, "POST", path, bp, nil)
+	}
+	return nil, restResponse(resp), err
+}
+
+func (gp *giteaProvider) RemoveBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Response, error) {
+	resp, err := gp.client.do(ctx, "DELETE", giteaRepoPath(owner, repo)+"/branch_protections/"+url.PathEscape(branch), nil, nil)
+	return restResponse(resp), err
+}
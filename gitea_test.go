@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestGiteaProviderMapsPolicy(t *testing.T) {
+	// Given
+	var created giteaBranchProtection
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/v1/orgs/mirrors/repos" && r.URL.Query().Get("page") == "1":
+			w.Header().Set("Link", `<http://gitea/api/v1/orgs/mirrors/repos?limit=50&page=2>; rel="next"`)
+			fmt.Fprint(w, `[{"id": 1, "name": "api", "full_name": "mirrors/api", "owner": {"login": "mirrors"}, "permissions": {"admin": true}}]`)
+		case r.Method == "GET" && r.URL.Path == "/api/v1/orgs/mirrors/repos":
+			fmt.Fprint(w, `[]`)
+		case r.Method == "GET" && r.URL.Path == "/api/v1/repos/mirrors/api/branches":
+			fmt.Fprint(w, `[{"name": "master", "protected": false}]`)
+		case r.Method == "POST" && r.URL.Path == "/api/v1/repos/mirrors/api/branch_protections":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, `{"message": "not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := newGiteaProvider(http.DefaultClient, server.URL+"/api/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.orgs = []string{"mirrors"}
+
+	failure := new(bytes.Buffer)
+	gp := &githubProtection{
+		repositoriesService: provider.service(),
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^master$")},
+		policy: &policy{
+			RequiredStatusChecks:       &statusChecksPolicy{Strict: true, Contexts: []string{"drone"}},
+			RequiredPullRequestReviews: &reviewsPolicy{DismissStaleReviews: true, RequiredApprovingReviewCount: 2},
+			Restrictions:               &restrictionsPolicy{Users: []string{"bot"}},
+		},
+		successOutput: new(bytes.Buffer),
+		failureOutput: failure,
+	}
+
+	// When
+	for repo := range provider.repositories().fetch(func(*result) {}) {
+		gp.protect(repo)
+	}
+
+	// Then
+	if failure.String() != "" {
+		t.Errorf("Was not expecting a failure, got: [%s]", failure.String())
+	}
+	if created.BranchName != "master" || !created.EnableStatusCheck || !created.BlockOnOutdatedBranch ||
+		created.RequiredApprovals != 2 || !created.DismissStaleApprovals ||
+		!created.EnablePushWhitelist || created.PushWhitelistUsernames[0] != "bot" {
+		t.Errorf("Unexpected branch protection: %+v", created)
+	}
+}
+
+func TestGiteaProviderIgnoresEnforceAdmins(t *testing.T) {
+	// Given
+	provider := &giteaProvider{}
+	p := &policy{EnforceAdmins: true, RequiredStatusChecks: &statusChecksPolicy{Contexts: []string{"drone"}}}
+
+	// When
+	supported := provider.supported(p)
+
+	// Then
+	if supported.EnforceAdmins || supported.RequiredStatusChecks == nil || !p.EnforceAdmins {
+		t.Errorf("Only enforce_admins should be removed, got: %+v", supported)
+	}
+	protection := &github.Protection{
+		RequiredStatusChecks: &github.RequiredStatusChecks{Contexts: []string{"drone"}},
+		EnforceAdmins:        &github.AdminEnforcement{Enabled: false},
+	}
+	if len(supported.check(protection, nil)) != 0 {
+		t.Error("Protection read from Gitea should match the supported policy")
+	}
+}
+
+func TestGiteaProviderReadsRequiredApprovals(t *testing.T) {
+	// Given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"branch_name": "master", "enable_push": true, "required_approvals": 1}`)
+	}))
+	defer server.Close()
+	provider, err := newGiteaProvider(http.DefaultClient, server.URL+"/api/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := provider.supported(&policy{RequiredPullRequestReviews: &reviewsPolicy{RequiredApprovingReviewCount: 2}})
+
+	// When
+	protection, settings, _, err := provider.GetBranchProtectionSettings(context.TODO(), "mirrors", "api", "master")
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findings := p.check(protection, settings); len(findings) != 1 || findings[0].rule != ruleApprovingReviews {
+		t.Errorf("Missing approval should be a finding, got %v", findings)
+	}
+	if len(provider.unsupported(p)) != 0 {
+		t.Errorf("Approval count should be supported, got %v", provider.unsupported(p))
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	gitlabNoAccess         = 0
+	gitlabDeveloperAccess  = 30
+	gitlabMaintainerAccess = 40
+	gitlabAdminAccess      = 60
+)
+
+var gitlabAccessLevels = map[string]int{
+	"no-one":     gitlabNoAccess,
+	"developer":  gitlabDeveloperAccess,
+	"maintainer": gitlabMaintainerAccess,
+	"admin":      gitlabAdminAccess,
+}
+
+// gitlabSettings are the protected branch settings only available on GitLab.
+type gitlabSettings struct {
+	pushAccess        string
+	mergeAccess       string
+	allowForcePush    bool
+	codeOwnerApproval bool
+}
+
+type gitlabProject struct {
+	ID                int    `json:"id"`
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+	Namespace         struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+	Permissions struct {
+		ProjectAccess *struct {
+			AccessLevel int `json:"access_level"`
+		} `json:"project_access"`
+		GroupAccess *struct {
+			AccessLevel int `json:"access_level"`
+		} `json:"group_access"`
+	} `json:"permissions"`
+}
+
+func (p *gitlabProject) toGitHub() *github.Repository {
+	id, name, fullName, owner, defaultBranch := p.ID, p.Path, p.PathWithNamespace, p.Namespace.FullPath, p.DefaultBranch
+	access := 0
+	if p.Permissions.ProjectAccess != nil && p.Permissions.ProjectAccess.AccessLevel > access {
+		access = p.Permissions.ProjectAccess.AccessLevel
+	}
+	if p.Permissions.GroupAccess != nil && p.Permissions.GroupAccess.AccessLevel > access {
+		access = p.Permissions.GroupAccess.AccessLevel
+	}
+	return &github.Repository{
+		ID:            &id,
+		Name:          &name,
+		FullName:      &fullName,
+		DefaultBranch: &defaultBranch,
+		Owner:         &github.User{Login: &owner},
+		Permissions: &map[string]bool{
+			"admin": access >= gitlabMaintainerAccess,
+		},
+	}
+}
+
+// gitlabProvider manages GitLab protected branches, -orgs selects groups (or users) and -repos selects projects.
+type gitlabProvider struct {
+	client        *restClient
+	orgs          []string
+	selectedRepos []string
+	settings      gitlabSettings
+}
+
+func newGitLabProvider(httpClient *http.Client, baseURL string, settings gitlabSettings) (*gitlabProvider, error) {
+	if baseURL == "" {
+		baseURL = "https://gitlab.com/api/v4/"
+	}
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, level := range []string{settings.pushAccess, settings.mergeAccess} {
+		if _, ok := gitlabAccessLevels[level]; !ok && level != "" {
+			return nil, fmt.Errorf("Unknown GitLab access level: %s", level)
+		}
+	}
+
+	return &gitlabProvider{
+		client:        &restClient{httpClient: httpClient, baseURL: u, pageParam: "per_page", pageSize: 100},
+		orgs:          orgs,
+		selectedRepos: protectRepositories,
+		settings:      settings,
+	}, nil
+}
+
+func (gp *gitlabProvider) repositories() repositories {
+	return gp
+}
+
+func (gp *gitlabProvider) service() repositoriesService {
+	return gp
+}
+
+func (gp *gitlabProvider) apiURL() *url.URL {
+	return gp.client.baseURL
+}
+
+// unsupported lists the policy settings that GitLab can't enforce, code owner approval and force pushes
+// are set with -gitlab-code-owner-approval and -gitlab-allow-force-push.
+func (gp *gitlabProvider) unsupported(p *policy) []string {
+	warnings := githubOnlySettings(p, "require_code_owner_reviews", "allow_force_pushes")
+	if p != nil && p.RequiredStatusChecks != nil {
+		warnings = append(warnings, "required_status_checks: pipelines are required by the project merge request settings, not protected branches")
+	}
+	if p != nil && p.RequiredPullRequestReviews != nil && p.RequiredPullRequestReviews.DismissStaleReviews {
+		warnings = append(warnings, "dismiss_stale_reviews: approvals are reset by the project merge request settings, not protected branches")
+	}
+	if p != nil && p.Restrictions != nil {
+		warnings = append(warnings, "restrictions: push access is given to a role with -gitlab-push-access")
+	}
+	return warnings
+}
+
+// supported removes from a policy the settings that GitLab can't enforce, they are reported once by unsupported
+// instead of on every branch.
+func (gp *gitlabProvider) supported(p *policy) *policy {
+	if p == nil {
+		return p
+	}
+	enforced := *p
+	enforced.RequiredStatusChecks = nil
+	enforced.Restrictions = nil
+	if reviews := p.RequiredPullRequestReviews; reviews != nil && reviews.DismissStaleReviews {
+		withoutDismissal := *reviews
+		withoutDismissal.DismissStaleReviews = false
+		enforced.RequiredPullRequestReviews = &withoutDismissal
+	}
+	return &enforced
+}
+
+// checkSettings lists the settings of a protected branch that differ from the -gitlab-* flags, the policy
+// can't describe them.
+func (gp *gitlabProvider) checkSettings(repo *github.Repository, branch string) ([]finding, error) {
+	protected := new(gitlabProtectedBranch)
+	if _, err := gp.client.do(context.TODO(), "GET", projectPath(*repo.Owner.Login, *repo.Name)+"/protected_branches/"+url.PathEscape(branch), nil, protected); err != nil {
+		return nil, err
+	}
+
+	var findings []finding
+	merge := gp.settings.mergeAccess
+	if merge == "" {
+		merge = "maintainer"
+	}
+	if len(accessChanges(protected.MergeAccessLevels, gitlabAccessLevels[merge])) > 0 {
+		findings = append(findings, finding{ruleGitLabMergeAccess, fmt.Sprintf("merge access is not given to %s only", merge)})
+	}
+	switch {
+	case protected.AllowForcePush && !gp.settings.allowForcePush:
+		findings = append(findings, finding{ruleGitLabForcePush, "force push is allowed"})
+	case !protected.AllowForcePush && gp.settings.allowForcePush:
+		findings = append(findings, finding{ruleGitLabForcePush, "force push is not allowed"})
+	}
+	switch {
+	case protected.CodeOwnerApprovalRequired && !gp.settings.codeOwnerApproval:
+		findings = append(findings, finding{ruleGitLabCodeOwnerApproval, "code owner approval is required"})
+	case !protected.CodeOwnerApprovalRequired && gp.settings.codeOwnerApproval:
+		findings = append(findings, finding{ruleGitLabCodeOwnerApproval, "code owner approval is not required"})
+	}
+	return findings, nil
+}
+
+func (gp *gitlabProvider) identity() (string, error) {
+	var user struct {
+		Username string `json:"username"`
+	}
+	if _, err := gp.client.do(context.TODO(), "GET", "user", nil, &user); err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
+
+func (gp *gitlabProvider) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, 20)
+	var wg sync.WaitGroup
+	send := func(page interface{}) {
+		for _, project := range *page.(*[]*gitlabProject) {
+			result <- project.toGitHub()
+		}
+	}
+	newPage := func() interface{} { return new([]*gitlabProject) }
+
+	switch {
+	case len(gp.selectedRepos) > 0:
+		for _, name := range gp.selectedRepos {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				project := new(gitlabProject)
+				if _, err := gp.client.do(context.TODO(), "GET", "projects/"+url.PathEscape(name), nil, project); err != nil {
+					unresolved(newUnresolvedResult(name, err))
+					return
+				}
+				result <- project.toGitHub()
+			}(name)
+		}
+	case len(gp.orgs) > 0:
+		for _, group := range gp.orgs {
+			wg.Add(1)
+			go func(group string) {
+				defer wg.Done()
+				err := gp.client.each(context.TODO(), "groups/"+url.PathEscape(group)+"/projects?include_subgroups=true&archived=false", newPage, send)
+				if e, ok := err.(*restError); ok && e.resp.StatusCode == http.StatusNotFound {
+					err = gp.client.each(context.TODO(), "users/"+url.PathEscape(group)+"/projects?archived=false", newPage, send)
+				}
+				if err != nil {
+					unresolved(newUnresolvedResult(group, err))
+				}
+			}(group)
+		}
+	default:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := gp.client.each(context.TODO(), "projects?membership=true&archived=false", newPage, send); err != nil {
+				unresolved(newUnresolvedResult("user", err))
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(result)
+	}()
+	return result
+}
+
+type gitlabAccessLevel struct {
+	ID          int  `json:"id"`
+	AccessLevel int  `json:"access_level"`
+	UserID      *int `json:"user_id"`
+	GroupID     *int `json:"group_id"`
+}
+
+type gitlabProtectedBranch struct {
+	Name                      string              `json:"name"`
+	PushAccessLevels          []gitlabAccessLevel `json:"push_access_levels"`
+	MergeAccessLevels         []gitlabAccessLevel `json:"merge_access_levels"`
+	AllowForcePush            bool                `json:"allow_force_push"`
+	CodeOwnerApprovalRequired bool                `json:"code_owner_approval_required"`
+}
+
+func projectPath(owner, repo string) string {
+	return "projects/" + url.PathEscape(owner+"/"+repo)
+}
+
+func (gp *gitlabProvider) ListBranches(ctx context.Context, owner string, repo string, opt *github.ListOptions) ([]*github.Branch, *github.Response, error) {
+	branches := make([]*github.Branch, 0)
+	err := gp.client.each(ctx, projectPath(owner, repo)+"/repository/branches", func() interface{} { return new([]*restBranch) }, func(page interface{}) {
+		for _, branch := range *page.(*[]*restBranch) {
+			branches = append(branches, branch.toGitHub())
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return branches, &github.Response{Response: &http.Response{StatusCode: http.StatusOK, Status: "200 OK"}}, nil
+}
+
+func (gp *gitlabProvider) GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error) {
+	branch := new(restBranch)
+	resp, err := gp.client.do(ctx, "GET", projectPath(owner, repo)+"/repository/branches/"+url.PathEscape(branchName), nil, branch)
+	if err != nil {
+		return nil, restResponse(resp), err
+	}
+	return branch.toGitHub(), restResponse(resp), nil
+}
+
+// GetBranchProtection describes a GitLab protected branch with the GitHub settings it is equivalent to:
+// when nobody can push, changes must go through merge requests, administrators included.
+func (gp *gitlabProvider) GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error) {
+	protected := new(gitlabProtectedBranch)
+	resp, err := gp.client.do(ctx, "GET", projectPath(owner, repo)+"/protected_branches/"+url.PathEscape(branch), nil, protected)
+	if err != nil {
+		return nil, restResponse(resp), err
+	}
+
+	pushAllowed := false
+	for _, level := range protected.PushAccessLevels {
+		if level.AccessLevel != gitlabNoAccess {
+			pushAllowed = true
+		}
+	}
+	protection := &github.Protection{EnforceAdmins: &github.AdminEnforcement{Enabled: !pushAllowed}}
+	if !pushAllowed {
+		protection.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcement{}
+	}
+	return protection, restResponse(resp), nil
+}
+
+// UpdateBranchProtection protects a branch, or changes the settings of an already protected branch in place.
+func (gp *gitlabProvider) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
+	push, merge := gp.settings.pushAccess, gp.settings.mergeAccess
+	if push == "" {
+		push = "maintainer"
+		if preq.RequiredPullRequestReviews != nil || preq.EnforceAdmins {
+			push = "no-one"
+		}
+	}
+	if merge == "" {
+		merge = "maintainer"
+	}
+
+	path := projectPath(owner, repo) + "/protected_branches"
+	protected := new(gitlabProtectedBranch)
+	resp, err := gp.client.do(ctx, "GET", path+"/"+url.PathEscape(branch), nil, protected)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, restResponse(resp), err
+	}
+	if err != nil {
+		body := map[string]interface{}{
+			"name":                         branch,
+			"push_access_level":            gitlabAccessLevels[push],
+			"merge_access_level":           gitlabAccessLevels[merge],
+			"allow_force_push":             gp.settings.allowForcePush,
+			"code_owner_approval_required": gp.settings.codeOwnerApproval,
+		}
+		resp, err = gp.client.do(ctx, "POST", path, body, nil)
+		return nil, restResponse(resp), err
+	}
+
+	body := map[string]interface{}{
+		"allowed_to_push":              accessChanges(protected.PushAccessLevels, gitlabAccessLevels[push]),
+		"allowed_to_merge":             accessChanges(protected.MergeAccessLevels, gitlabAccessLevels[merge]),
+		"allow_force_push":             gp.settings.allowForcePush,
+		"code_owner_approval_required": gp.settings.codeOwnerApproval,
+	}
+	resp, err = gp.client.do(ctx, "PATCH", path+"/"+url.PathEscape(branch), body, nil)
+	return nil, restResponse(resp), err
+}
+
+// accessChanges replaces the access levels of a protected branch with the expected role, the levels
+// granted to other roles, users or groups are destroyed.
+func accessChanges(current []gitlabAccessLevel, expected int) []map[string]interface{} {
+	changes := make([]map[string]interface{}, 0)
+	found := false
+	for _, level := range current {
+		if level.AccessLevel == expected && level.UserID == nil && level.GroupID == nil && !found {
+			found = true
+			continue
+		}
+		changes = append(changes, map[string]interface{}{"id": level.ID, "_destroy": true})
+	}
+	if !found {
+		changes = append(changes, map[string]interface{}{"access_level": expected})
+	}
+	return changes
+}
+
+func (gp *gitlabProvider) RemoveBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Response, error) {
+	resp, err := gp.client.do(ctx, "DELETE", projectPath(owner, repo)+"/protected_branches/"+url.PathEscape(branch), nil, nil)
+	return restResponse(resp), err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// fakeGitLab is a local stand-in of the GitLab API with one project and a master branch, protected when
+// existing is set.
+type fakeGitLab struct {
+	existing  string
+	protected map[string]interface{}
+	updated   map[string]interface{}
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.EscapedPath() == "/api/v4/groups/platform/projects" && r.URL.Query().Get("per_page") != "100":
+		http.Error(w, `{"message": "unexpected page size"}`, http.StatusBadRequest)
+	case r.Method == "GET" && r.URL.EscapedPath() == "/api/v4/groups/platform/projects":
+		fmt.Fprint(w, `[{"id": 1, "path": "api", "path_with_namespace": "platform/api", "namespace": {"full_path": "platform"},
+  "permissions": {"project_access": null, "group_access": {"access_level": 40}}}]`)
+	case r.Method == "GET" && r.URL.EscapedPath() == "/api/v4/projects/platform%2Fapi/repository/branches":
+		w.Header().Set("X-Next-Page", "")
+		fmt.Fprint(w, `[{"name": "master", "protected": false}, {"name": "feature", "protected": false}]`)
+	case r.Method == "GET" && r.URL.EscapedPath() == "/api/v4/projects/platform%2Fapi/protected_branches/master" && f.existing != "":
+		fmt.Fprint(w, f.existing)
+	case r.Method == "PATCH" && r.URL.EscapedPath() == "/api/v4/projects/platform%2Fapi/protected_branches/master":
+		json.NewDecoder(r.Body).Decode(&f.updated)
+		fmt.Fprint(w, `{}`)
+	case r.Method == "POST" && r.URL.EscapedPath() == "/api/v4/projects/platform%2Fapi/protected_branches":
+		json.NewDecoder(r.Body).Decode(&f.protected)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{}`)
+	default:
+		http.Error(w, `{"message": "404 Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func TestGitLabProviderProtectsBranches(t *testing.T) {
+	// Given
+	fake := &fakeGitLab{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := newGitLabProvider(http.DefaultClient, server.URL+"/api/v4", gitlabSettings{mergeAccess: "developer", codeOwnerApproval: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.orgs = []string{"platform"}
+
+	success := new(bytes.Buffer)
+	failure := new(bytes.Buffer)
+	gp := &githubProtection{
+		repositoriesService: provider.service(),
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^master$")},
+		policy:              &policy{RequiredPullRequestReviews: &reviewsPolicy{}},
+		successOutput:       success,
+		failureOutput:       failure,
+	}
+
+	// When
+	for repo := range provider.repositories().fetch(func(*result) {}) {
+		gp.protect(repo)
+	}
+
+	// Then
+	if failure.String() != "" {
+		t.Errorf("Was not expecting a failure, got: [%s]", failure.String())
+	}
+	if success.String() != "platform/api: master is now protected\n" {
+		t.Errorf("master should be protected, got: [%s]", success.String())
+	}
+	expected := map[string]interface{}{
+		"name":                         "master",
+		"push_access_level":            float64(gitlabNoAccess),
+		"merge_access_level":           float64(gitlabDeveloperAccess),
+		"allow_force_push":             false,
+		"code_owner_approval_required": true,
+	}
+	if fmt.Sprint(fake.protected) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected protected branch request: %v", fake.protected)
+	}
+}
+
+func TestGitLabProviderUpdatesProtectedBranchesInPlace(t *testing.T) {
+	// Given
+	fake := &fakeGitLab{existing: `{"name": "master",
+  "push_access_levels": [{"id": 1, "access_level": 40}],
+  "merge_access_levels": [{"id": 2, "access_level": 40}, {"id": 3, "access_level": 40, "user_id": 7}]}`}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := newGitLabProvider(http.DefaultClient, server.URL+"/api/v4", gitlabSettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	_, _, err = provider.UpdateBranchProtection(context.TODO(), "platform", "api", "master", &github.ProtectionRequest{EnforceAdmins: true})
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.protected != nil {
+		t.Errorf("Protected branch should not be created again, got: %v", fake.protected)
+	}
+	expected := map[string]interface{}{
+		"allowed_to_push": []interface{}{
+			map[string]interface{}{"id": float64(1), "_destroy": true},
+			map[string]interface{}{"access_level": float64(gitlabNoAccess)},
+		},
+		"allowed_to_merge": []interface{}{
+			map[string]interface{}{"id": float64(3), "_destroy": true},
+		},
+		"allow_force_push":             false,
+		"code_owner_approval_required": false,
+	}
+	if fmt.Sprint(fake.updated) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected protected branch update: %v", fake.updated)
+	}
+}
+
+func TestGitLabProviderReportsEnforcedAdminsWhenNobodyCanPush(t *testing.T) {
+	for levels, enforced := range map[string]bool{
+		`[{"id": 1, "access_level": 0}]`:  true,
+		`[{"id": 1, "access_level": 40}]`: false,
+	} {
+		// Given
+		server := httptest.NewServer(&fakeGitLab{existing: `{"name": "master", "push_access_levels": ` + levels + `}`})
+		provider, err := newGitLabProvider(http.DefaultClient, server.URL+"/api/v4", gitlabSettings{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// When
+		protection, _, err := provider.GetBranchProtection(context.TODO(), "platform", "api", "master")
+		server.Close()
+
+		// Then
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protection.EnforceAdmins.Enabled != enforced {
+			t.Errorf("Push access %s should enforce protection for administrators: %t", levels, enforced)
+		}
+	}
+}
+
+func TestGitLabProviderIgnoresStatusChecks(t *testing.T) {
+	// Given
+	provider := &gitlabProvider{}
+	forcePushes := false
+	p := &policy{
+		RequiredStatusChecks:       &statusChecksPolicy{Contexts: []string{"ci"}},
+		RequiredPullRequestReviews: &reviewsPolicy{RequireCodeOwnerReviews: true},
+		EnforceAdmins:              true,
+		AllowForcePushes:           &forcePushes,
+	}
+
+	// When
+	supported := provider.supported(p)
+
+	// Then
+	if supported.RequiredStatusChecks != nil || !supported.EnforceAdmins {
+		t.Errorf("Only status checks should be removed, got: %+v", supported)
+	}
+	if len(provider.unsupported(p)) != 1 || p.RequiredStatusChecks == nil {
+		t.Errorf("Status checks should be reported as unsupported, got: %v", provider.unsupported(p))
+	}
+}
+
+func TestGitLabProviderIgnoresPushRestrictionsAndStaleReviews(t *testing.T) {
+	// Given
+	provider := &gitlabProvider{}
+	p := &policy{
+		RequiredPullRequestReviews: &reviewsPolicy{DismissStaleReviews: true},
+		Restrictions:               &restrictionsPolicy{Users: []string{"bot"}},
+	}
+
+	// When
+	supported := provider.supported(p)
+
+	// Then
+	if supported.Restrictions != nil || supported.RequiredPullRequestReviews == nil || supported.RequiredPullRequestReviews.DismissStaleReviews {
+		t.Errorf("Push restrictions and stale reviews dismissal should be removed, got: %+v", supported)
+	}
+	if len(provider.unsupported(p)) != 2 || !p.RequiredPullRequestReviews.DismissStaleReviews {
+		t.Errorf("Push restrictions and stale reviews dismissal should be reported as unsupported, got: %v", provider.unsupported(p))
+	}
+}
+
+func TestGitLabProviderReportsSettingsDifferentFromFlags(t *testing.T) {
+	// Given
+	server := httptest.NewServer(&fakeGitLab{existing: `{"name": "master",
+  "push_access_levels": [{"id": 1, "access_level": 0}],
+  "merge_access_levels": [{"id": 2, "access_level": 40}],
+  "allow_force_push": true}`})
+	defer server.Close()
+	provider, err := newGitLabProvider(http.DefaultClient, server.URL+"/api/v4", gitlabSettings{mergeAccess: "developer", codeOwnerApproval: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, name := "platform", "api"
+
+	// When
+	findings, err := provider.checkSettings(&github.Repository{Name: &name, Owner: &github.User{Login: &owner}}, "master")
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []finding{
+		{ruleGitLabMergeAccess, "merge access is not given to developer only"},
+		{ruleGitLabForcePush, "force push is allowed"},
+		{ruleGitLabCodeOwnerApproval, "code owner approval is not required"},
+	}
+	if fmt.Sprint(findings) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected findings %v", findings)
+	}
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+type graphqlClient struct {
+	httpClient *http.Client
+	endpoint   string
+}
+
+// graphqlEndpoint derives the GraphQL endpoint from the REST API URL,
+// GitHub Enterprise serves it under /api/graphql instead of /api/v3.
+func graphqlEndpoint(baseURL *url.URL) string {
+	endpoint := *baseURL
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	if strings.HasSuffix(endpoint.Path, "/v3") {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/v3")
+	}
+	endpoint.Path += "/graphql"
+	return endpoint.String()
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+}
+
+func (gc *graphqlClient) query(ctx context.Context, query string, variables map[string]interface{}, data interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", gc.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gc.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Received HTTP response [%s] from GraphQL API", resp.Status)
+	}
+
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphqlError  `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		messages := make([]string, len(response.Errors))
+		for i, e := range response.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("GraphQL API error: %s", strings.Join(messages, ", "))
+	}
+	return json.Unmarshal(response.Data, data)
+}
+
+const graphqlRepositoryFields = `
+fragment repositoryFields on Repository {
+  id
+  name
+  nameWithOwner
+  owner { login }
+  viewerPermission
+  isPrivate
+  isFork
+  primaryLanguage { name }
+  repositoryTopics(first: 100) { nodes { topic { name } } }
+  defaultBranchRef { name }
+  refs(refPrefix: "refs/heads/", first: 100) {
+    pageInfo { hasNextPage endCursor }
+    nodes { name branchProtectionRule { id } }
+  }
+  branchProtectionRules(first: 20) {
+    pageInfo { hasNextPage endCursor }
+    nodes { ...protectionRuleFields }
+  }
+}
+` + graphqlProtectionRuleFields
+
+const graphqlProtectionRuleFields = `
+fragment protectionRuleFields on BranchProtectionRule {
+  id
+  pattern
+  requiresStatusChecks
+  requiresStrictStatusChecks
+  requiredStatusCheckContexts
+  requiresApprovingReviews
+  dismissesStaleReviews
+  requiresCodeOwnerReviews
+  requiredApprovingReviewCount
+  requiresLinearHistory
+  allowsForcePushes
+  allowsDeletions
+  requiresCommitSignatures
+  isAdminEnforced
+  restrictsPushes
+  pushAllowances(first: 100) {
+    pageInfo { hasNextPage endCursor }
+    nodes { actor { __typename ... on User { login } ... on Team { slug } } }
+  }
+}
+`
+
+type graphqlPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type graphqlRefs struct {
+	PageInfo graphqlPageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Name                 string `json:"name"`
+		BranchProtectionRule *struct {
+			ID string `json:"id"`
+		} `json:"branchProtectionRule"`
+	} `json:"nodes"`
+}
+
+type graphqlProtectionRule struct {
+	ID                           string                `json:"id"`
+	Pattern                      string                `json:"pattern"`
+	RequiresStatusChecks         bool                  `json:"requiresStatusChecks"`
+	RequiresStrictStatusChecks   bool                  `json:"requiresStrictStatusChecks"`
+	RequiredStatusCheckContexts  []string              `json:"requiredStatusCheckContexts"`
+	RequiresApprovingReviews     bool                  `json:"requiresApprovingReviews"`
+	DismissesStaleReviews        bool                  `json:"dismissesStaleReviews"`
+	RequiresCodeOwnerReviews     bool                  `json:"requiresCodeOwnerReviews"`
+	RequiredApprovingReviewCount int                   `json:"requiredApprovingReviewCount"`
+	RequiresLinearHistory        bool                  `json:"requiresLinearHistory"`
+	AllowsForcePushes            bool                  `json:"allowsForcePushes"`
+	AllowsDeletions              bool                  `json:"allowsDeletions"`
+	RequiresCommitSignatures     bool                  `json:"requiresCommitSignatures"`
+	IsAdminEnforced              bool                  `json:"isAdminEnforced"`
+	RestrictsPushes              bool                  `json:"restrictsPushes"`
+	PushAllowances               graphqlPushAllowances `json:"pushAllowances"`
+}
+
+type graphqlPushAllowances struct {
+	PageInfo graphqlPageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Actor struct {
+			Typename string `json:"__typename"`
+			Login    string `json:"login"`
+			Slug     string `json:"slug"`
+		} `json:"actor"`
+	} `json:"nodes"`
+}
+
+type graphqlProtectionRules struct {
+	PageInfo graphqlPageInfo          `json:"pageInfo"`
+	Nodes    []*graphqlProtectionRule `json:"nodes"`
+}
+
+type graphqlRepository struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	NameWithOwner string `json:"nameWithOwner"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	ViewerPermission string       `json:"viewerPermission"`
+	IsPrivate        bool         `json:"isPrivate"`
+	IsFork           bool         `json:"isFork"`
+	PrimaryLanguage  *graphqlName `json:"primaryLanguage"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic graphqlName `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+	DefaultBranchRef      *graphqlName           `json:"defaultBranchRef"`
+	Refs                  graphqlRefs            `json:"refs"`
+	BranchProtectionRules graphqlProtectionRules `json:"branchProtectionRules"`
+}
+
+type graphqlName struct {
+	Name string `json:"name"`
+}
+
+type graphqlRepositories struct {
+	PageInfo graphqlPageInfo      `json:"pageInfo"`
+	Nodes    []*graphqlRepository `json:"nodes"`
+}
+
+// graphqlBackend reads repositories, branches and their protection with a few batched GraphQL queries
+// instead of one REST call per repository and branch. Protection changes still go through the REST API.
+type graphqlBackend struct {
+	repositoriesService
+	client        *graphqlClient
+	orgs          []string
+	selectedRepos []string
+	mu            sync.Mutex
+	inventory     map[string]*graphqlRepository
+}
+
+func (gb *graphqlBackend) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, 20)
+	go func() {
+		defer close(result)
+		switch {
+		case len(gb.selectedRepos) > 0:
+			for start := 0; start < len(gb.selectedRepos); start += 20 {
+				end := start + 20
+				if end > len(gb.selectedRepos) {
+					end = len(gb.selectedRepos)
+				}
+				gb.fetchSelected(gb.selectedRepos[start:end], result, unresolved)
+			}
+		case len(gb.orgs) > 0:
+			for _, org := range gb.orgs {
+				if err := gb.fetchPages(org, result); err != nil {
+					unresolved(newUnresolvedResult(org, err))
+				}
+			}
+		default:
+			if err := gb.fetchPages("", result); err != nil {
+				unresolved(newUnresolvedResult("user", err))
+			}
+		}
+	}()
+	return result
+}
+
+func (gb *graphqlBackend) fetchPages(org string, result chan *github.Repository) error {
+	query := `query($cursor: String) {
+  viewer {
+    repositories(first: 25, after: $cursor, affiliations: [OWNER, COLLABORATOR, ORGANIZATION_MEMBER]) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...repositoryFields }
+    }
+  }
+}` + graphqlRepositoryFields
+	variables := map[string]interface{}{"cursor": nil}
+	if org != "" {
+		query = `query($org: String!, $cursor: String) {
+  viewer: organization(login: $org) {
+    repositories(first: 25, after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...repositoryFields }
+    }
+  }
+}` + graphqlRepositoryFields
+		variables["org"] = org
+	}
+
+	for {
+		var data struct {
+			Viewer struct {
+				Repositories graphqlRepositories `json:"repositories"`
+			} `json:"viewer"`
+		}
+		if err := gb.client.query(context.TODO(), query, variables, &data); err != nil {
+			return err
+		}
+
+		for _, repo := range data.Viewer.Repositories.Nodes {
+			if err := gb.add(repo, result); err != nil {
+				return err
+			}
+		}
+
+		page := data.Viewer.Repositories.PageInfo
+		if !page.HasNextPage {
+			return nil
+		}
+		variables["cursor"] = page.EndCursor
+	}
+}
+
+// fetchSelected queries a batch of selected repositories using one alias per repository. The whole batch
+// fails when one of them can't be resolved, its repositories are then queried one by one.
+func (gb *graphqlBackend) fetchSelected(names []string, result chan *github.Repository, unresolved func(*result)) {
+	query := new(bytes.Buffer)
+	query.WriteString("query {\n")
+	for i, name := range names {
+		metas := strings.SplitN(name, "/", 2)
+		fmt.Fprintf(query, "  r%d: repository(owner: %q, name: %q) { ...repositoryFields }\n", i, metas[0], metas[1])
+	}
+	query.WriteString("}\n")
+	query.WriteString(graphqlRepositoryFields)
+
+	var data map[string]*graphqlRepository
+	if err := gb.client.query(context.TODO(), query.String(), nil, &data); err != nil {
+		if len(names) == 1 {
+			unresolved(newUnresolvedResult(names[0], err))
+			return
+		}
+		for _, name := range names {
+			gb.fetchSelected([]string{name}, result, unresolved)
+		}
+		return
+	}
+	for i, name := range names {
+		repo := data[fmt.Sprintf("r%d", i)]
+		if repo == nil {
+			unresolved(newUnresolvedResult(name, errors.New("repository not found")))
+			continue
+		}
+		if err := gb.add(repo, result); err != nil {
+			unresolved(newUnresolvedResult(name, err))
+		}
+	}
+}
+
+// add completes the branches and protection rules of a repository having more than a page of them, then publishes it.
+func (gb *graphqlBackend) add(repo *graphqlRepository, result chan *github.Repository) error {
+	refs := repo.Refs
+	for refs.PageInfo.HasNextPage {
+		var data struct {
+			Repository struct {
+				Refs graphqlRefs `json:"refs"`
+			} `json:"repository"`
+		}
+		query := `query($owner: String!, $name: String!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    refs(refPrefix: "refs/heads/", first: 100, after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      nodes { name branchProtectionRule { id } }
+    }
+  }
+}`
+		variables := map[string]interface{}{"owner": repo.Owner.Login, "name": repo.Name, "cursor": refs.PageInfo.EndCursor}
+		if err := gb.client.query(context.TODO(), query, variables, &data); err != nil {
+			return err
+		}
+		refs = data.Repository.Refs
+		repo.Refs.Nodes = append(repo.Refs.Nodes, refs.Nodes...)
+	}
+	if err := gb.client.completeRules(repo.Owner.Login, repo.Name, &repo.BranchProtectionRules); err != nil {
+		return err
+	}
+
+	gb.mu.Lock()
+	if gb.inventory == nil {
+		gb.inventory = make(map[string]*graphqlRepository)
+	}
+	gb.inventory[repo.NameWithOwner] = repo
+	gb.mu.Unlock()
+
+	result <- repo.toGitHub()
+	return nil
+}
+
+// completeRules fetches the protection rules, and their push allowances, beyond the first page.
+func (gc *graphqlClient) completeRules(owner, name string, rules *graphqlProtectionRules) error {
+	page := rules.PageInfo
+	for page.HasNextPage {
+		var data struct {
+			Repository struct {
+				BranchProtectionRules graphqlProtectionRules `json:"branchProtectionRules"`
+			} `json:"repository"`
+		}
+		query := `query($owner: String!, $name: String!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    branchProtectionRules(first: 100, after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...protectionRuleFields }
+    }
+  }
+}` + graphqlProtectionRuleFields
+		variables := map[string]interface{}{"owner": owner, "name": name, "cursor": page.EndCursor}
+		if err := gc.query(context.TODO(), query, variables, &data); err != nil {
+			return err
+		}
+		page = data.Repository.BranchProtectionRules.PageInfo
+		rules.Nodes = append(rules.Nodes, data.Repository.BranchProtectionRules.Nodes...)
+	}
+
+	for _, rule := range rules.Nodes {
+		allowances := rule.PushAllowances.PageInfo
+		for allowances.HasNextPage {
+			var data struct {
+				Node struct {
+					PushAllowances graphqlPushAllowances `json:"pushAllowances"`
+				} `json:"node"`
+			}
+			query := `query($id: ID!, $cursor: String) {
+  node(id: $id) {
+    ... on BranchProtectionRule {
+      pushAllowances(first: 100, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        nodes { actor { __typename ... on User { login } ... on Team { slug } } }
+      }
+    }
+  }
+}`
+			variables := map[string]interface{}{"id": rule.ID, "cursor": allowances.EndCursor}
+			if err := gc.query(context.TODO(), query, variables, &data); err != nil {
+				return err
+			}
+			allowances = data.Node.PushAllowances.PageInfo
+			rule.PushAllowances.Nodes = append(rule.PushAllowances.Nodes, data.Node.PushAllowances.Nodes...)
+		}
+	}
+	return nil
+}
+
+func (repo *graphqlRepository) toGitHub() *github.Repository {
+	name, fullName, login, private, fork := repo.Name, repo.NameWithOwner, repo.Owner.Login, repo.IsPrivate, repo.IsFork
+	converted := &github.Repository{
+		Name:     &name,
+		FullName: &fullName,
+		Owner:    &github.User{Login: &login},
+		Private:  &private,
+		Fork:     &fork,
+		Permissions: &map[string]bool{
+			"admin": repo.ViewerPermission == "ADMIN",
+		},
+	}
+	if repo.PrimaryLanguage != nil {
+		converted.Language = &repo.PrimaryLanguage.Name
+	}
+	if repo.DefaultBranchRef != nil {
+		converted.DefaultBranch = &repo.DefaultBranchRef.Name
+	}
+	for _, node := range repo.RepositoryTopics.Nodes {
+		converted.Topics = append(converted.Topics, node.Topic.Name)
+	}
+	return converted
+}
+
+func (gb *graphqlBackend) repository(owner, repo string) (*graphqlRepository, error) {
+	gb.mu.Lock()
+	defer gb.mu.Unlock()
+	if found, ok := gb.inventory[owner+"/"+repo]; ok {
+		return found, nil
+	}
+	return nil, fmt.Errorf("%s/%s has not been fetched with GraphQL", owner, repo)
+}
+
+func (gb *graphqlBackend) ListBranches(ctx context.Context, owner string, repo string, opt *github.ListOptions) ([]*github.Branch, *github.Response, error) {
+	found, err := gb.repository(owner, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	branches := make([]*github.Branch, 0, len(found.Refs.Nodes))
+	for _, ref := range found.Refs.Nodes {
+		name := ref.Name
+		protected := ref.BranchProtectionRule != nil
+		branches = append(branches, &github.Branch{Name: &name, Protected: &protected})
+	}
+	resp := &github.Response{Response: &http.Response{StatusCode: http.StatusOK, Status: "200 OK"}}
+	return branches, resp, nil
+}
+
+func (gb *graphqlBackend) GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error) {
+	branches, resp, err := gb.ListBranches(ctx, owner, repo, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, branch := range branches {
+		if *branch.Name == branchName {
+			return branch, resp, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%s/%s has no branch %s", owner, repo, branchName)
+}
+
+func (gb *graphqlBackend) GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error) {
+	rule, err := gb.rule(owner, repo, branch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rule.toGitHub(), nil, nil
+}
+
+func (gb *graphqlBackend) GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error) {
+	rule, err := gb.rule(owner, repo, branch)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return rule.toGitHub(), rule.settings(), nil, nil
+}
+
+// UpdateBranchProtectionSettings changes the protection with the REST API.
+func (gb *graphqlBackend) UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error) {
+	if service, ok := gb.repositoriesService.(settingsService); ok {
+		return service.UpdateBranchProtectionSettings(ctx, owner, repo, branch, preq, settings)
+	}
+	return gb.repositoriesService.UpdateBranchProtection(ctx, owner, repo, branch, preq)
+}
+
+func (gb *graphqlBackend) rule(owner, repo, branch string) (*graphqlProtectionRule, error) {
+	found, err := gb.repository(owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range found.Refs.Nodes {
+		if ref.Name != branch || ref.BranchProtectionRule == nil {
+			continue
+		}
+		for _, rule := range found.BranchProtectionRules.Nodes {
+			if rule.ID == ref.BranchProtectionRule.ID {
+				return rule, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s/%s: %s has no protection rule", owner, repo, branch)
+}
+
+func (rule *graphqlProtectionRule) settings() *protectionSettings {
+	return &protectionSettings{
+		RequiredApprovingReviewCount: rule.RequiredApprovingReviewCount,
+		RequireCodeOwnerReviews:      rule.RequiresCodeOwnerReviews,
+		RequiredLinearHistory:        rule.RequiresLinearHistory,
+		AllowForcePushes:             rule.AllowsForcePushes,
+		AllowDeletions:               rule.AllowsDeletions,
+		RequiredSignatures:           rule.RequiresCommitSignatures,
+	}
+}
+
+func (rule *graphqlProtectionRule) toGitHub() *github.Protection {
+	protection := &github.Protection{
+		EnforceAdmins: &github.AdminEnforcement{Enabled: rule.IsAdminEnforced},
+	}
+	if rule.RequiresStatusChecks {
+		protection.RequiredStatusChecks = &github.RequiredStatusChecks{
+			Strict:   rule.RequiresStrictStatusChecks,
+			Contexts: rule.RequiredStatusCheckContexts,
+		}
+	}
+	if rule.RequiresApprovingReviews {
+		protection.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcement{
+			DismissStaleReviews: rule.DismissesStaleReviews,
+		}
+	}
+	if rule.RestrictsPushes {
+		protection.Restrictions = &github.BranchRestrictions{}
+		for _, allowance := range rule.PushAllowances.Nodes {
+			switch allowance.Actor.Typename {
+			case "User":
+				login := allowance.Actor.Login
+				protection.Restrictions.Users = append(protection.Restrictions.Users, &github.User{Login: &login})
+			case "Team":
+				slug := allowance.Actor.Slug
+				protection.Restrictions.Teams = append(protection.Restrictions.Teams, &github.Team{Slug: &slug})
+			}
+		}
+	}
+	return protection
+}
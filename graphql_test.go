@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLBackendReadsBranchesAndProtection(t *testing.T) {
+	// Given
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		fmt.Fprint(w, `{"data": {"viewer": {"repositories": {
+  "pageInfo": {"hasNextPage": false},
+  "nodes": [{
+    "id": "R1", "name": "maven-color", "nameWithOwner": "jcgay/maven-color", "owner": {"login": "jcgay"},
+    "viewerPermission": "ADMIN", "isPrivate": true, "isFork": false, "primaryLanguage": {"name": "Java"},
+    "repositoryTopics": {"nodes": [{"topic": {"name": "maven"}}]}, "defaultBranchRef": {"name": "master"},
+    "refs": {"pageInfo": {"hasNextPage": false}, "nodes": [
+      {"name": "master", "branchProtectionRule": {"id": "P1"}},
+      {"name": "develop", "branchProtectionRule": null}
+    ]},
+    "branchProtectionRules": {"nodes": [{
+      "id": "P1", "pattern": "master", "requiresStatusChecks": true, "requiredStatusCheckContexts": ["ci/travis"],
+      "isAdminEnforced": true, "pushAllowances": {"nodes": []}
+    }]}
+  }]
+}}}}`)
+	}))
+	defer server.Close()
+
+	gb := &graphqlBackend{
+		client: &graphqlClient{httpClient: http.DefaultClient, endpoint: server.URL},
+		orgs:   []string{"jcgay"},
+	}
+
+	// When
+	repos := make([]string, 0)
+	for repo := range gb.fetch(func(*result) {}) {
+		repos = append(repos, *repo.FullName)
+		if !(*repo.Permissions)["admin"] {
+			t.Errorf("Viewer should be admin of %s", *repo.FullName)
+		}
+		if !repo.GetPrivate() || repo.GetFork() || repo.GetLanguage() != "Java" || repo.GetDefaultBranch() != "master" || repo.Topics[0] != "maven" {
+			t.Errorf("Repository attributes should be read, got: %v", repo)
+		}
+	}
+	branches, _, err := gb.ListBranches(context.TODO(), "jcgay", "maven-color", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protection, _, err := gb.GetBranchProtection(context.TODO(), "jcgay", "maven-color", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	if queries != 1 || len(repos) != 1 {
+		t.Errorf("Expected one query returning one repository, got %d queries and %v", queries, repos)
+	}
+	if len(branches) != 2 || !*branches[0].Protected || *branches[1].Protected {
+		t.Errorf("Only master should be protected, got: %v", branches)
+	}
+	if !protection.EnforceAdmins.Enabled || protection.RequiredStatusChecks.Contexts[0] != "ci/travis" {
+		t.Errorf("Protection should be read from the rule, got: %v", protection)
+	}
+}
+
+func TestGraphQLBackendPaginatesRulesAndPushAllowances(t *testing.T) {
+	// Given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "node(id: $id)"):
+			fmt.Fprint(w, `{"data": {"node": {"pushAllowances": {"pageInfo": {"hasNextPage": false},
+  "nodes": [{"actor": {"__typename": "Team", "slug": "core"}}]}}}}`)
+		case strings.Contains(string(body), "branchProtectionRules(first: 100, after: $cursor)"):
+			fmt.Fprint(w, `{"data": {"repository": {"branchProtectionRules": {"pageInfo": {"hasNextPage": false}, "nodes": [{
+  "id": "P2", "pattern": "release", "restrictsPushes": true,
+  "pushAllowances": {"pageInfo": {"hasNextPage": true, "endCursor": "A1"}, "nodes": [{"actor": {"__typename": "User", "login": "jcgay"}}]}
+}]}}}}`)
+		default:
+			fmt.Fprint(w, `{"data": {"viewer": {"repositories": {
+  "pageInfo": {"hasNextPage": false},
+  "nodes": [{
+    "id": "R1", "name": "maven-color", "nameWithOwner": "jcgay/maven-color", "owner": {"login": "jcgay"},
+    "viewerPermission": "ADMIN",
+    "refs": {"pageInfo": {"hasNextPage": false}, "nodes": [{"name": "release", "branchProtectionRule": {"id": "P2"}}]},
+    "branchProtectionRules": {"pageInfo": {"hasNextPage": true, "endCursor": "C1"}, "nodes": [{
+      "id": "P1", "pattern": "master", "pushAllowances": {"pageInfo": {"hasNextPage": false}, "nodes": []}
+    }]}
+  }]
+}}}}`)
+		}
+	}))
+	defer server.Close()
+
+	gb := &graphqlBackend{
+		client: &graphqlClient{httpClient: http.DefaultClient, endpoint: server.URL},
+		orgs:   []string{"jcgay"},
+	}
+
+	// When
+	for range gb.fetch(func(*result) {}) {
+	}
+	protection, _, err := gb.GetBranchProtection(context.TODO(), "jcgay", "maven-color", "release")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	restrictions := protection.Restrictions
+	if len(restrictions.Users) != 1 || *restrictions.Users[0].Login != "jcgay" || len(restrictions.Teams) != 1 || *restrictions.Teams[0].Slug != "core" {
+		t.Errorf("Push allowances should be read from every page, got: %v", restrictions)
+	}
+}
+
+func TestGraphQLEndpoint(t *testing.T) {
+	for base, expected := range map[string]string{
+		"https://api.github.com/":            "https://api.github.com/graphql",
+		"https://github.example.com/api/v3/": "https://github.example.com/api/graphql",
+	} {
+		u, _ := url.Parse(base)
+		if got := graphqlEndpoint(u); got != expected {
+			t.Errorf("GraphQL endpoint of %s should be %s, got: %s", base, expected, got)
+		}
+	}
+}
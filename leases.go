@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lease records a branch freed for a limited time with the protection it had, to be restored once expired.
+type lease struct {
+	Owner      string              `json:"owner"`
+	Repository string              `json:"repository"`
+	Branch     string              `json:"branch"`
+	Expires    time.Time           `json:"expires"`
+	Protection *github.Protection  `json:"protection"`
+	Settings   *protectionSettings `json:"settings,omitempty"`
+}
+
+func (l *lease) repo() *github.Repository {
+	fullName := l.Owner + "/" + l.Repository
+	return &github.Repository{
+		Name:     &l.Repository,
+		FullName: &fullName,
+		Owner:    &github.User{Login: &l.Owner},
+	}
+}
+
+// leaseStore keeps leases in a JSON file, it is read again before every change so that
+// concurrent runs don't lose leases of each other.
+type leaseStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func defaultLeaseFile() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "protector", "leases.json")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "state", "protector", "leases.json")
+}
+
+func (ls *leaseStore) load() ([]*lease, error) {
+	content, err := ioutil.ReadFile(ls.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var leases []*lease
+	if err := json.Unmarshal(content, &leases); err != nil {
+		return nil, fmt.Errorf("Can't read leases %s: %v", ls.path, err)
+	}
+	return leases, nil
+}
+
+// save writes leases atomically, the file is removed when there is no lease left.
+func (ls *leaseStore) save(leases []*lease) error {
+	if len(leases) == 0 {
+		if err := os.Remove(ls.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	content, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ls.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(ls.path), ".leases")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), ls.path)
+}
+
+// add records a lease, replacing the one of the same branch.
+func (ls *leaseStore) add(l *lease) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	leases, err := ls.load()
+	if err != nil {
+		return err
+	}
+	kept := []*lease{l}
+	for _, existing := range leases {
+		if existing.Owner != l.Owner || existing.Repository != l.Repository || existing.Branch != l.Branch {
+			kept = append(kept, existing)
+		}
+	}
+	return ls.save(kept)
+}
+
+// active returns the lease of a branch that has not expired at now, nil when the branch is not leased.
+func (ls *leaseStore) active(owner, repo, branch string, now time.Time) (*lease, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	leases, err := ls.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range leases {
+		if l.Owner == owner && l.Repository == repo && l.Branch == branch && now.Before(l.Expires) {
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+// expire restores the protection of every expired lease, leases that can't be restored are kept to be tried again.
+func (ls *leaseStore) expire(gp *githubProtection, now time.Time) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	leases, err := ls.load()
+	if err != nil {
+		return err
+	}
+
+	kept := make([]*lease, 0)
+	for _, l := range leases {
+		if now.Before(l.Expires) {
+			kept = append(kept, l)
+			continue
+		}
+		r := gp.restore(l)
+		gp.report(r)
+		if r.status == statusFailed || r.status == statusToProtect {
+			kept = append(kept, l)
+		}
+	}
+	return ls.save(kept)
+}
+
+// restore protects a branch again with the protection it had before being freed.
+func (gp *githubProtection) restore(l *lease) *result {
+	repo := l.repo()
+	branch, _, err := gp.repositoriesService.GetBranch(context.TODO(), l.Owner, l.Repository, l.Branch)
+	if err != nil {
+		return newResult(repo, l.Branch, statusFailed, err.Error())
+	}
+	if branch.GetProtected() {
+		return newResult(repo, l.Branch, statusAlreadyProtected, "lease expired, protection was already restored")
+	}
+
+	if gp.dryrun {
+		return newResult(repo, l.Branch, statusToProtect, "lease expired, protection will be restored", finding{ruleProtected, "branch is not protected"})
+	}
+
+	if err := gp.updateProtection(repo, l.Branch, protectionRequest(l.Protection), l.Settings); err != nil {
+		return newResult(repo, l.Branch, statusFailed, err.Error())
+	}
+	return newResult(repo, l.Branch, statusProtected, "lease expired, protection is now restored")
+}
+
+// protectionRequest builds the request setting a protection read from the API.
+func protectionRequest(p *github.Protection) *github.ProtectionRequest {
+	req := &github.ProtectionRequest{}
+	if p == nil {
+		return req
+	}
+
+	if checks := p.RequiredStatusChecks; checks != nil {
+		req.RequiredStatusChecks = &github.RequiredStatusChecks{Strict: checks.Strict, Contexts: nonNil(checks.Contexts)}
+	}
+	if p.EnforceAdmins != nil {
+		req.EnforceAdmins = p.EnforceAdmins.Enabled
+	}
+	if reviews := p.RequiredPullRequestReviews; reviews != nil {
+		req.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcementRequest{
+			DismissStaleReviews: reviews.DismissStaleReviews,
+		}
+		if users, teams := logins(reviews.DismissalRestrictions.Users), slugs(reviews.DismissalRestrictions.Teams); len(users) > 0 || len(teams) > 0 {
+			req.RequiredPullRequestReviews.DismissalRestrictionsRequest = &github.DismissalRestrictionsRequest{Users: users, Teams: teams}
+		}
+	}
+	if p.Restrictions != nil {
+		req.Restrictions = &github.BranchRestrictionsRequest{
+			Users: logins(p.Restrictions.Users),
+			Teams: slugs(p.Restrictions.Teams),
+		}
+	}
+	return req
+}
+
+func logins(users []*github.User) []string {
+	result := make([]string, 0, len(users))
+	for _, user := range users {
+		result = append(result, user.GetLogin())
+	}
+	return result
+}
+
+func slugs(teams []*github.Team) []string {
+	result := make([]string, 0, len(teams))
+	for _, team := range teams {
+		result = append(result, team.GetSlug())
+	}
+	return result
+}
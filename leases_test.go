@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/go-github/github"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestLeaseMock struct {
+	TestProtectRepositoryMock
+	protected map[string]bool
+	restored  *github.ProtectionRequest
+}
+
+func (p *TestLeaseMock) GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error) {
+	protected := p.protected[branchName]
+	return &github.Branch{Name: &branchName, Protected: &protected}, nil, nil
+}
+
+func (p *TestLeaseMock) GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error) {
+	return &github.Protection{
+		RequiredStatusChecks: &github.RequiredStatusChecks{Strict: true, Contexts: []string{"ci/travis"}},
+		EnforceAdmins:        &github.AdminEnforcement{Enabled: true},
+	}, nil, nil
+}
+
+func (p *TestLeaseMock) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
+	p.restored = preq
+	p.protected[branch] = true
+	return nil, nil, nil
+}
+
+func (p *TestLeaseMock) RemoveBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Response, error) {
+	p.protected[branch] = false
+	return nil, nil
+}
+
+func TestFreeForDurationRestoresProtectionOnceExpired(t *testing.T) {
+	// Given
+	dir, err := ioutil.TempDir("", "protector-leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	success := new(bytes.Buffer)
+	mock := &TestLeaseMock{protected: map[string]bool{"master": true}}
+	gp := &githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^master$")},
+		successOutput:       success,
+		failureOutput:       success,
+		leases:              &leaseStore{path: filepath.Join(dir, "leases.json")},
+		leaseDuration:       2 * time.Hour,
+	}
+	repoName, login, fullName := "maven-color", "jcgay", "jcgay/maven-color"
+	repository := &github.Repository{Name: &repoName, FullName: &fullName, Owner: &github.User{Login: &login}, Permissions: &map[string]bool{"admin": true}}
+
+	// When
+	gp.unlock(repository, &github.Branch{Name: github.String("master")})
+	if err := gp.leases.expire(gp, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gp.leases.expire(gp, time.Now().Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	if mock.restored == nil || !mock.restored.EnforceAdmins || mock.restored.RequiredStatusChecks.Contexts[0] != "ci/travis" {
+		t.Errorf("Protection should be restored as it was before being freed, got %+v", mock.restored)
+	}
+	if !strings.Contains(success.String(), "jcgay/maven-color: master lease expired, protection is now restored") {
+		t.Errorf("Restoration should be reported, got: [%s]", success.String())
+	}
+	if leases, _ := gp.leases.load(); len(leases) != 0 {
+		t.Errorf("Restored lease should be removed, got %v", leases)
+	}
+}
+
+func TestExpireReportsLeaseAlreadyRestored(t *testing.T) {
+	// Given
+	dir, err := ioutil.TempDir("", "protector-leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	success := new(bytes.Buffer)
+	mock := &TestLeaseMock{protected: map[string]bool{"master": true}}
+	gp := &githubProtection{repositoriesService: mock, successOutput: success, failureOutput: success, leases: &leaseStore{path: filepath.Join(dir, "leases.json")}}
+	gp.leases.add(&lease{Owner: "jcgay", Repository: "maven-color", Branch: "master", Expires: time.Now().Add(-time.Minute)})
+
+	// When
+	if err := gp.leases.expire(gp, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	if mock.restored != nil {
+		t.Error("Protection restored by hand should not be updated")
+	}
+	if success.String() != "jcgay/maven-color: master lease expired, protection was already restored\n" {
+		t.Errorf("Lease restored by hand should be reported, got: [%s]", success.String())
+	}
+}
+
+func TestIntervalRunsSkipLeasedBranches(t *testing.T) {
+	// Given
+	dir, err := ioutil.TempDir("", "protector-leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	success := new(bytes.Buffer)
+	mock := &TestLeaseMock{protected: map[string]bool{"master": false}}
+	gp := &githubProtection{
+		repositoriesService: mock,
+		successOutput:       success,
+		failureOutput:       success,
+		leases:              &leaseStore{path: filepath.Join(dir, "leases.json")},
+		skipLeased:          true,
+	}
+	expires := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+	gp.leases.add(&lease{Owner: "jcgay", Repository: "maven-color", Branch: "master", Expires: expires})
+	repoName, login, fullName := "maven-color", "jcgay", "jcgay/maven-color"
+	repository := &github.Repository{Name: &repoName, FullName: &fullName, Owner: &github.User{Login: &login}, Permissions: &map[string]bool{"admin": true}}
+
+	// When
+	locked := gp.lock(repository, &github.Branch{Name: github.String("master")}, &policy{EnforceAdmins: true})
+	unlocked := gp.unlock(repository, &github.Branch{Name: github.String("master")})
+
+	// Then
+	if mock.restored != nil {
+		t.Errorf("Leased branch should not be protected again, got %+v", mock.restored)
+	}
+	for _, r := range []*result{locked, unlocked} {
+		if r.status != statusAlreadyFree || r.message != "jcgay/maven-color: master is leased until 2030-01-02T15:04:05Z" {
+			t.Errorf("Leased branch should be skipped, got: %v %s", r.status, r.message)
+		}
+	}
+}
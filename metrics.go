@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metrics exposes what protector is doing using the Prometheus text format.
+type metrics struct {
+	mu              sync.Mutex
+	branches        *metricVec
+	apiRequests     *metricVec
+	rateRemaining   *metricVec
+	outOfCompliance *metricVec
+	cycleDuration   *histogram
+	cycleRepos      map[string]map[string]bool
+	basePath        string
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		branches:        newMetricVec("protector_branches_total", "Branches processed, by organization and result.", "counter", "org", "status"),
+		apiRequests:     newMetricVec("protector_api_requests_total", "GitHub API calls, by endpoint and HTTP status code.", "counter", "endpoint", "code"),
+		rateRemaining:   newMetricVec("protector_rate_limit_remaining", "Remaining GitHub API calls in the current rate limit window.", "gauge"),
+		outOfCompliance: newMetricVec("protector_repositories_out_of_compliance", "Repositories with at least one branch not in the expected state during the last cycle.", "gauge", "org"),
+		cycleDuration:   newHistogram("protector_cycle_duration_seconds", "Duration of a complete protection cycle.", []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}),
+		cycleRepos:      make(map[string]map[string]bool),
+	}
+}
+
+func (m *metrics) notify(r *result) {
+	m.branches.add(1, r.org(), r.status.String())
+
+	if r.status == statusNoAdmin {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	repos, ok := m.cycleRepos[r.org()]
+	if !ok {
+		repos = make(map[string]bool)
+		m.cycleRepos[r.org()] = repos
+	}
+	if compliant, seen := repos[*r.repo.FullName]; !seen || compliant {
+		repos[*r.repo.FullName] = r.status.compliant()
+	}
+}
+
+func (m *metrics) startCycle() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cycleRepos = make(map[string]map[string]bool)
+}
+
+func (m *metrics) endCycle(elapsed time.Duration) {
+	m.cycleDuration.observe(elapsed.Seconds())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outOfCompliance.reset()
+	for org, repos := range m.cycleRepos {
+		count := 0
+		for _, compliant := range repos {
+			if !compliant {
+				count++
+			}
+		}
+		m.outOfCompliance.set(float64(count), org)
+	}
+}
+
+func (m *metrics) observeResponse(req *http.Request, resp *http.Response) {
+	m.apiRequests.add(1, endpoint(req, m.basePath), strconv.Itoa(resp.StatusCode))
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+		if value, err := strconv.ParseFloat(remaining, 64); err == nil {
+			m.rateRemaining.set(value)
+		}
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.branches.writeTo(w)
+	m.apiRequests.writeTo(w)
+	m.rateRemaining.writeTo(w)
+	m.outOfCompliance.writeTo(w)
+	m.cycleDuration.writeTo(w)
+}
+
+// endpointPlaceholders replaces the segment following a collection of an API path.
+var endpointPlaceholders = map[string]string{
+	"orgs":               "{org}",
+	"users":              "{user}",
+	"teams":              "{team}",
+	"groups":             "{group}",
+	"projects":           "{project}",
+	"repos":              "{repo}",
+	"branches":           "{branch}",
+	"protected_branches": "{branch}",
+	"branch_protections": "{branch}",
+	"restrictions":       "{id}",
+	"commits":            "{sha}",
+	"collaborators":      "{user}",
+	"contents":           "{path}",
+	"files":              "{path}",
+}
+
+// endpoint replaces owner, repository, organization and branch names of an API path with placeholders
+// so that API calls can be counted without creating a serie per repository. The path of the API base URL
+// is removed first, Bitbucket paths keep their rest/<api>/<version> prefix.
+func endpoint(req *http.Request, basePath string) string {
+	path := strings.TrimPrefix(req.URL.EscapedPath(), strings.TrimSuffix(basePath, "/"))
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	start := 0
+	switch {
+	case len(segments) > 3 && segments[0] == "rest":
+		start = 3
+	case len(segments) >= 3 && segments[0] == "repos":
+		segments[1], segments[2] = "{owner}", "{repo}"
+		start = 3
+		if len(segments) > 4 && segments[3] == "branches" {
+			tail := []string{"{branch}"}
+			for i := len(segments) - 1; i > 4; i-- {
+				if segments[i] == "protection" {
+					tail = append(tail, segments[i:]...)
+					break
+				}
+			}
+			segments = append(segments[:4], tail...)
+			start = len(segments)
+		}
+	}
+
+	for i := start; i+1 < len(segments); i++ {
+		placeholder, ok := endpointPlaceholders[segments[i]]
+		if !ok {
+			continue
+		}
+		if placeholder == "{path}" {
+			segments = append(segments[:i+1], placeholder)
+			break
+		}
+		segments[i+1] = placeholder
+		i++
+	}
+	return req.Method + " /" + strings.Join(segments, "/")
+}
+
+type instrumentedTransport struct {
+	base    http.RoundTripper
+	metrics *metrics
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.metrics.observeResponse(req, resp)
+	}
+	return resp, err
+}
+
+type metricVec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	values map[string]float64
+}
+
+func newMetricVec(name, help, kind string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+func (mv *metricVec) key(labelValues []string) string {
+	pairs := make([]string, len(mv.labels))
+	for i, label := range mv.labels {
+		pairs[i] = fmt.Sprintf("%s=%q", label, labelValues[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (mv *metricVec) add(value float64, labelValues ...string) {
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+	mv.values[mv.key(labelValues)] += value
+}
+
+func (mv *metricVec) set(value float64, labelValues ...string) {
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+	mv.values[mv.key(labelValues)] = value
+}
+
+func (mv *metricVec) reset() {
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+	mv.values = make(map[string]float64)
+}
+
+func (mv *metricVec) writeTo(w io.Writer) {
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", mv.name, mv.help, mv.name, mv.kind)
+	keys := make([]string, 0, len(mv.values))
+	for key := range mv.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Fprintf(w, "%s %g\n", mv.name, mv.values[key])
+		} else {
+			fmt.Fprintf(w, "%s{%s} %g\n", mv.name, key, mv.values[key])
+		}
+	}
+}
+
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(name, help string, bounds []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		bounds:  bounds,
+		buckets: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, bound, h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", h.name, h.sum, h.name, h.count)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/go-github/github"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointHidesNames(t *testing.T) {
+	cases := map[string]string{
+		"/user/repos":                                         "GET /user/repos",
+		"/orgs/docker/repos":                                  "GET /orgs/{org}/repos",
+		"/repos/jcgay/maven-color":                            "GET /repos/{owner}/{repo}",
+		"/repos/jcgay/maven-color/branches":                   "GET /repos/{owner}/{repo}/branches",
+		"/repos/jcgay/maven-color/branches/release/1.0":       "GET /repos/{owner}/{repo}/branches/{branch}",
+		"/repos/jcgay/maven-color/branches/master/protection": "GET /repos/{owner}/{repo}/branches/{branch}/protection",
+		"/repos/jcgay/maven-color/branches/release/1.0/protection/required_signatures": "GET /repos/{owner}/{repo}/branches/{branch}/protection/required_signatures",
+	}
+
+	for path, expected := range cases {
+		req, _ := http.NewRequest("GET", "https://api.github.com"+path, nil)
+		if got := endpoint(req, "/"); got != expected {
+			t.Errorf("Endpoint for [%s] should be [%s], got: [%s]", path, expected, got)
+		}
+	}
+}
+
+func TestEndpointHidesNamesBehindBasePath(t *testing.T) {
+	cases := []struct {
+		url, basePath, expected string
+	}{
+		{"https://ghe.example.com/api/v3/repos/jcgay/maven-color/branches/master/protection", "/api/v3/", "GET /repos/{owner}/{repo}/branches/{branch}/protection"},
+		{"https://ghe.example.com/api/v3/repos/jcgay/maven-color/commits/abc123/check-runs", "/api/v3/", "GET /repos/{owner}/{repo}/commits/{sha}/check-runs"},
+		{"https://gitlab.com/api/v4/groups/platform%2Fsub/projects", "/api/v4/", "GET /groups/{group}/projects"},
+		{"https://gitlab.com/api/v4/projects/platform%2Fapi/protected_branches/release%2F1.0", "/api/v4/", "GET /projects/{project}/protected_branches/{branch}"},
+		{"https://gitlab.com/api/v4/projects/platform%2Fapi/repository/branches/master", "/api/v4/", "GET /projects/{project}/repository/branches/{branch}"},
+		{"https://gitea.example.com/api/v1/repos/mirrors/api/branch_protections/master", "/api/v1", "GET /repos/{owner}/{repo}/branch_protections/{branch}"},
+		{"https://bitbucket.example.com/bitbucket/rest/api/1.0/projects/PRJ/repos/api/branches", "/bitbucket", "GET /rest/api/1.0/projects/{project}/repos/{repo}/branches"},
+		{"https://bitbucket.example.com/rest/branch-permissions/2.0/projects/PRJ/repos/api/restrictions/12", "", "GET /rest/branch-permissions/2.0/projects/{project}/repos/{repo}/restrictions/{id}"},
+	}
+
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", c.url, nil)
+		if got := endpoint(req, c.basePath); got != c.expected {
+			t.Errorf("Endpoint for [%s] should be [%s], got: [%s]", c.url, c.expected, got)
+		}
+	}
+}
+
+func TestMetricsCountRepositoriesOutOfCompliance(t *testing.T) {
+	// Given
+	m := newMetrics()
+	login := "jcgay"
+	first, second := "jcgay/maven-color", "jcgay/protector"
+
+	// When
+	m.startCycle()
+	for _, r := range []*result{
+		{repo: &github.Repository{FullName: &first, Owner: &github.User{Login: &login}}, branch: "master", status: statusProtected},
+		{repo: &github.Repository{FullName: &first, Owner: &github.User{Login: &login}}, branch: "develop", status: statusFailed},
+		{repo: &github.Repository{FullName: &second, Owner: &github.User{Login: &login}}, branch: "master", status: statusAlreadyProtected},
+	} {
+		m.notify(r)
+	}
+	m.endCycle(2 * time.Second)
+
+	// Then
+	out := new(bytes.Buffer)
+	m.outOfCompliance.writeTo(out)
+	if !strings.Contains(out.String(), `protector_repositories_out_of_compliance{org="jcgay"} 1`) {
+		t.Errorf("One repository should be out of compliance, got: [%s]", out.String())
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const ruleInspection = "branch-inspection"
+
+var ruleDescriptions = map[string]string{
+	ruleProtected:            "Branch must be protected",
+	ruleStatusChecks:         "Status checks must be required before merging",
+	ruleStrictStatusChecks:   "Branches must be up to date before merging",
+	ruleStatusCheckContext:   "Every status check of the policy must be required",
+	rulePullRequestReviews:   "Pull request reviews must be required before merging",
+	ruleDismissStaleReviews:  "Approving reviews must be dismissed when new commits are pushed",
+	ruleEnforceAdmins:        "Protection must be enforced for administrators",
+	rulePushRestrictions:     "Push access must be restricted",
+	rulePushRestrictionsUser: "Only users of the policy can push",
+	rulePushRestrictionsTeam: "Only teams of the policy can push",
+	ruleInspection:           "Branch protection must be readable",
+	ruleRepoPolicy:           "Repository policy file must be valid",
+	ruleReportedContext:      "Required status checks must be reported by recent commits",
+	ruleCodeOwnerReviews:     "Code owners must review pull requests",
+	ruleCodeOwnersFile:       "A CODEOWNERS file must exist to require code owner reviews",
+	ruleCodeOwner:            "Code owners must exist and be able to write to the repository",
+	ruleApprovingReviews:     "Enough approving reviews must be required",
+	ruleLinearHistory:        "Linear history must be required",
+	ruleForcePushes:          "Force pushes must be blocked",
+	ruleDeletions:            "Branch deletion must be blocked",
+	ruleSignatures:           "Signed commits must be required",
+
+	ruleGitLabMergeAccess:       "GitLab merge access must be given to the role of -gitlab-merge-access",
+	ruleGitLabForcePush:         "GitLab force push must match -gitlab-allow-force-push",
+	ruleGitLabCodeOwnerApproval: "GitLab code owner approval must match -gitlab-code-owner-approval",
+}
+
+// sorted returns the collected results ordered by repository and branch.
+func (rc *reportCollector) sorted() []*result {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	results := make([]*result, len(rc.results))
+	copy(results, rc.results)
+	sort.Slice(results, func(i, j int) bool {
+		if *results[i].repo.FullName == *results[j].repo.FullName {
+			return results[i].branch < results[j].branch
+		}
+		return *results[i].repo.FullName < *results[j].repo.FullName
+	})
+	return results
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// writeJUnit writes one test case per branch, grouped by repository.
+func writeJUnit(w io.Writer, results []*result) error {
+	suites := new(junitTestSuites)
+	byRepo := make(map[string]*junitTestSuite)
+	for _, r := range results {
+		name := *r.repo.FullName
+		suite, ok := byRepo[name]
+		if !ok {
+			suite = &junitTestSuite{Name: name}
+			byRepo[name] = suite
+			suites.Suites = append(suites.Suites, suite)
+		}
+
+		tc := &junitTestCase{Name: r.branch, ClassName: name}
+		if r.branch == "" {
+			tc.Name = "branches"
+		}
+		switch {
+		case r.status == statusNoAdmin:
+			tc.Skipped = &junitProblem{Message: r.message}
+			suite.Skipped++
+		case r.status == statusFailed || r.status == statusUnresolved:
+			tc.Error = &junitProblem{Message: r.message}
+			suite.Errors++
+		case !r.status.compliant():
+			messages := make([]string, 0, len(r.findings))
+			for _, f := range r.findings {
+				messages = append(messages, fmt.Sprintf("[%s] %s", f.rule, f.message))
+			}
+			tc.Failure = &junitProblem{Message: r.message, Content: strings.Join(messages, "\n")}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type sarifLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool      `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string       `json:"name"`
+	Version        string       `json:"version"`
+	InformationURI string       `json:"informationUri"`
+	Rules          []*sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string           `json:"ruleId"`
+	Level     string           `json:"level"`
+	Message   sarifMessage     `json:"message"`
+	Locations []*sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []*sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// writeSARIF writes one SARIF result per finding, each missing setting having its own rule.
+func writeSARIF(w io.Writer, results []*result, toolVersion string) error {
+	run := &sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "protector",
+			Version:        toolVersion,
+			InformationURI: "https://github.com/jcgay/protector",
+			Rules:          make([]*sarifRule, 0),
+		}},
+		Results: make([]*sarifResult, 0),
+	}
+
+	usedRules := make(map[string]bool)
+	add := func(r *result, rule, level, message string) {
+		location := *r.repo.FullName
+		if r.branch != "" {
+			location += "@" + r.branch
+		}
+		run.Results = append(run.Results, &sarifResult{
+			RuleID:  rule,
+			Level:   level,
+			Message: sarifMessage{Text: fmt.Sprintf("%s: %s", location, message)},
+			Locations: []*sarifLocation{{LogicalLocations: []*sarifLogicalLocation{{
+				FullyQualifiedName: location,
+				Kind:               "branch",
+			}}}},
+		})
+		usedRules[rule] = true
+	}
+
+	for _, r := range results {
+		switch {
+		case r.status.failed() && len(r.findings) == 0:
+			add(r, ruleInspection, "warning", r.message)
+		case !r.status.compliant():
+			for _, f := range r.findings {
+				add(r, f.rule, "error", f.message)
+			}
+		}
+	}
+
+	for rule := range usedRules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, &sarifRule{
+			ID:               rule,
+			ShortDescription: sarifMessage{Text: ruleDescriptions[rule]},
+		})
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool { return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID })
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []*sarifRun{run},
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/google/go-github/github"
+	"strings"
+	"testing"
+)
+
+func outputResults() []*result {
+	login := "jcgay"
+	repoName := "jcgay/maven-color"
+	repo := &github.Repository{FullName: &repoName, Owner: &github.User{Login: &login}}
+	return []*result{
+		newResult(repo, "develop", statusAlreadyProtected, "is already protected"),
+		newResult(repo, "master", statusToUpdate, "protection will be updated",
+			finding{ruleEnforceAdmins, "protection is not enforced for administrators"},
+			finding{ruleStatusCheckContext, "status check coverage is not required"}),
+	}
+}
+
+func TestJUnitReportsNonCompliantBranchesAsFailures(t *testing.T) {
+	// Given
+	out := new(bytes.Buffer)
+
+	// When
+	if err := writeJUnit(out, outputResults()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	for _, expected := range []string{
+		`<testsuite name="jcgay/maven-color" tests="2" failures="1" errors="0" skipped="0">`,
+		`<testcase name="develop" classname="jcgay/maven-color"></testcase>`,
+		`<failure message="jcgay/maven-color: master protection will be updated">[enforce-admins] protection is not enforced for administrators`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("JUnit report should contain [%s], got: [%s]", expected, out.String())
+		}
+	}
+}
+
+func TestSARIFReportsOneResultByFinding(t *testing.T) {
+	// Given
+	out := new(bytes.Buffer)
+
+	// When
+	if err := writeSARIF(out, outputResults(), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	run := log.Runs[0]
+	if len(run.Results) != 2 || len(run.Tool.Driver.Rules) != 2 {
+		t.Errorf("Expected 2 results and 2 rules, got: [%s]", out.String())
+	}
+	if run.Results[0].RuleID != ruleEnforceAdmins || run.Results[0].Locations[0].LogicalLocations[0].FullyQualifiedName != "jcgay/maven-color@master" {
+		t.Errorf("Unexpected first result: [%s]", out.String())
+	}
+}
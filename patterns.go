@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const globPrefix = "glob:"
+
+// compileBranchPattern compiles a branch regexp, or a glob when it starts with "glob:".
+func compileBranchPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, globPrefix) {
+		return compileGlob(strings.TrimPrefix(pattern, globPrefix))
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid branch regexp %s: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// compileGlob converts a glob using the fnmatch syntax of GitHub branch protection rules to a regexp:
+// `*` matches anything but `/`, `**` matches anything, `?` matches one character but `/`
+// and `[...]` (or `[!...]`) matches a character of a set.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var re bytes.Buffer
+	re.WriteString("^")
+
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("Invalid branch glob %s: trailing backslash", glob)
+			}
+			i++
+			re.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("Invalid branch glob %s: missing ]", glob)
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i = end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid branch glob %s: %v", glob, err)
+	}
+	return compiled, nil
+}
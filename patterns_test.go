@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestGlobPatterns(t *testing.T) {
+	for pattern, cases := range map[string]map[string]bool{
+		"glob:release/*": {
+			"release/1.0":   true,
+			"release/1/fix": false,
+			"release":       false,
+		},
+		"glob:feature/**": {
+			"feature/login":       true,
+			"feature/login/oauth": true,
+			"features/login":      false,
+		},
+		"glob:v?.[0-9]": {
+			"v1.2":  true,
+			"v1.x":  false,
+			"v/.2":  false,
+			"v10.2": false,
+		},
+		"glob:[!m]aster": {
+			"master": false,
+			"faster": true,
+		},
+		"^master$": {
+			"master": true,
+		},
+	} {
+		re, err := compileBranchPattern(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for branch, expected := range cases {
+			if re.MatchString(branch) != expected {
+				t.Errorf("%s matching %s should be %t", pattern, branch, expected)
+			}
+		}
+	}
+}
+
+func TestInvalidPatternsAreReported(t *testing.T) {
+	for _, pattern := range []string{"glob:release/[0-9", "glob:release\\", "release/(.*"} {
+		if _, err := compileBranchPattern(pattern); err == nil {
+			t.Errorf("%s should be invalid", pattern)
+		}
+	}
+}
+
+func TestGlobsCanBeUsedAsRules(t *testing.T) {
+	for glob, expected := range map[string]string{
+		"glob:release/*":  "release/*",
+		"glob:feature/**": "feature/**",
+		"glob:master":     "master",
+	} {
+		re, _ := compileBranchPattern(glob)
+		if got, err := toWildcard(re.String()); err != nil || got != expected {
+			t.Errorf("%s should be converted back to %s, got: %s (%v)", glob, expected, got, err)
+		}
+	}
+}
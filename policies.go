@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// policyFields are the settings of a policy, in the order they are explained.
+var policyFields = []string{"required_status_checks", "required_pull_request_reviews", "enforce_admins", "restrictions",
+	"required_linear_history", "allow_force_pushes", "allow_deletions", "required_signatures"}
+
+// policyLayer holds the settings a level of the configuration sets, the other ones are inherited.
+type policyLayer map[string]json.RawMessage
+
+// policyConfig is a hierarchy of policies: global settings are overridden by the settings of the organization,
+// then of the profiles selected by rules, of the repository topics and teams, and finally of the repository itself.
+type policyConfig struct {
+	global   policyLayer
+	orgs     map[string]policyLayer
+	profiles map[string]policyLayer
+	rules    []*policyRule
+	topics   map[string]policyLayer
+	teams    map[string]policyLayer
+	repos    map[string]policyLayer
+}
+
+// policyRule applies a profile to the repositories matching all the attributes of its selector.
+type policyRule struct {
+	Match   policySelector `json:"match"`
+	Profile string         `json:"profile"`
+}
+
+// policySelector matches repository attributes, the ones left empty match any repository.
+type policySelector struct {
+	Visibility    string   `json:"visibility"`
+	Topics        []string `json:"topics"`
+	Language      string   `json:"language"`
+	Fork          *bool    `json:"fork"`
+	Archived      *bool    `json:"archived"`
+	Owner         string   `json:"owner"`
+	Name          string   `json:"name"`
+	DefaultBranch string   `json:"default_branch"`
+	name          *regexp.Regexp
+}
+
+var selectorFields = []string{"visibility", "topics", "language", "fork", "archived", "owner", "name", "default_branch"}
+
+func (ps *policySelector) UnmarshalJSON(content []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return err
+	}
+	for key := range fields {
+		if !contains(selectorFields, key) {
+			return fmt.Errorf("unknown selector %s", key)
+		}
+	}
+
+	type selector policySelector
+	if err := json.Unmarshal(content, (*selector)(ps)); err != nil {
+		return err
+	}
+	if ps.Visibility != "" && ps.Visibility != "public" && ps.Visibility != "private" {
+		return fmt.Errorf("visibility must be public or private, got %s", ps.Visibility)
+	}
+	if ps.Name != "" {
+		re, err := regexp.Compile(ps.Name)
+		if err != nil {
+			return fmt.Errorf("invalid name regexp %s: %v", ps.Name, err)
+		}
+		ps.name = re
+	}
+	return nil
+}
+
+// matches tells if a repository has every attribute of the selector.
+func (ps *policySelector) matches(repo *github.Repository, archived bool) bool {
+	switch {
+	case ps.Visibility == "private" && !repo.GetPrivate(),
+		ps.Visibility == "public" && repo.GetPrivate(),
+		len(missing(ps.Topics, repo.Topics)) > 0,
+		ps.Language != "" && !strings.EqualFold(ps.Language, repo.GetLanguage()),
+		ps.Fork != nil && *ps.Fork != repo.GetFork(),
+		ps.Archived != nil && *ps.Archived != archived,
+		ps.Owner != "" && !strings.EqualFold(ps.Owner, *repo.Owner.Login),
+		ps.name != nil && !ps.name.MatchString(repo.GetName()),
+		ps.DefaultBranch != "" && ps.DefaultBranch != repo.GetDefaultBranch():
+		return false
+	}
+	return true
+}
+
+// namedLayer is a layer applying to a repository, with the name explaining where settings come from.
+type namedLayer struct {
+	name   string
+	fields policyLayer
+}
+
+// readPolicy reads a policy file, its top level settings are global and can be overridden
+// under "orgs", "profiles" applied by "rules", "topics", "teams" (as org/team-slug) and "repos" (as owner/repo).
+// Other top level fields are ignored, so that a saved branch protection API request still reads as a policy.
+func readPolicy(path string) (*policyConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := parsePolicyConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read policy %s: %v", path, err)
+	}
+	return pc, nil
+}
+
+func parsePolicyConfig(content []byte) (*policyConfig, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(content, &top); err != nil {
+		return nil, err
+	}
+
+	pc := &policyConfig{global: make(policyLayer)}
+	levels := map[string]*map[string]policyLayer{"orgs": &pc.orgs, "profiles": &pc.profiles, "topics": &pc.topics, "teams": &pc.teams, "repos": &pc.repos}
+	for key, value := range top {
+		if key == "rules" {
+			if err := json.Unmarshal(value, &pc.rules); err != nil {
+				return nil, fmt.Errorf("rules: %v", err)
+			}
+			continue
+		}
+		if level, ok := levels[key]; ok {
+			if err := json.Unmarshal(value, level); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			continue
+		}
+		if contains(policyFields, key) {
+			pc.global[key] = value
+		}
+	}
+
+	if err := pc.global.check(); err != nil {
+		return nil, err
+	}
+	for key, level := range levels {
+		for name, layer := range *level {
+			if err := layer.check(); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", key, name, err)
+			}
+		}
+	}
+	for i, rule := range pc.rules {
+		if _, ok := pc.profiles[rule.Profile]; !ok {
+			return nil, fmt.Errorf("rule %d: unknown profile %s", i+1, rule.Profile)
+		}
+	}
+	return pc, nil
+}
+
+// check refuses unknown settings and settings that can't be read as a policy.
+func (pl policyLayer) check() error {
+	for key := range pl {
+		if !contains(policyFields, key) {
+			return fmt.Errorf("unknown setting %s", key)
+		}
+	}
+	_, err := pl.policy()
+	return err
+}
+
+func (pl policyLayer) policy() (*policy, error) {
+	content, err := json.Marshal(pl)
+	if err != nil {
+		return nil, err
+	}
+	p := new(policy)
+	return p, json.Unmarshal(content, p)
+}
+
+// needsTeams tells if resolving a policy depends on the teams of the repository.
+func (pc *policyConfig) needsTeams() bool {
+	return pc != nil && len(pc.teams) > 0
+}
+
+// needsArchived tells if resolving a policy depends on the repository being archived.
+func (pc *policyConfig) needsArchived() bool {
+	if pc == nil {
+		return false
+	}
+	for _, rule := range pc.rules {
+		if rule.Match.Archived != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// restricted tells if any level of the configuration sets push restrictions.
+func (pc *policyConfig) restricted() bool {
+	if pc == nil {
+		return false
+	}
+	for _, layer := range pc.all() {
+		if restrictions, ok := layer[policyFields[3]]; ok && !bytes.Equal(restrictions, []byte("null")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pc *policyConfig) all() []policyLayer {
+	layers := []policyLayer{pc.global}
+	for _, level := range []map[string]policyLayer{pc.orgs, pc.profiles, pc.topics, pc.teams, pc.repos} {
+		for _, layer := range level {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
+}
+
+// layers lists the layers applying to a repository, from the least to the most specific.
+func (pc *policyConfig) layers(repo *github.Repository, teams []string, archived bool) []namedLayer {
+	layers := []namedLayer{{"global", pc.global}}
+	if layer, ok := pc.orgs[*repo.Owner.Login]; ok {
+		layers = append(layers, namedLayer{"org " + *repo.Owner.Login, layer})
+	}
+	for i, rule := range pc.rules {
+		if rule.Match.matches(repo, archived) {
+			layers = append(layers, namedLayer{fmt.Sprintf("rule %d (profile %s)", i+1, rule.Profile), pc.profiles[rule.Profile]})
+		}
+	}
+	topics := append([]string(nil), repo.Topics...)
+	sort.Strings(topics)
+	for _, topic := range topics {
+		if layer, ok := pc.topics[topic]; ok {
+			layers = append(layers, namedLayer{"topic " + topic, layer})
+		}
+	}
+	sort.Strings(teams)
+	for _, team := range teams {
+		if layer, ok := pc.teams[team]; ok {
+			layers = append(layers, namedLayer{"team " + team, layer})
+		}
+	}
+	if layer, ok := pc.repos[*repo.FullName]; ok {
+		layers = append(layers, namedLayer{"repo " + *repo.FullName, layer})
+	}
+	return layers
+}
+
+// resolve computes the policy of a repository, with the name of the layer each setting comes from.
+func (pc *policyConfig) resolve(repo *github.Repository, teams []string, archived bool) (*policy, map[string]string, error) {
+	if pc == nil {
+		return nil, map[string]string{}, nil
+	}
+
+	effective := make(policyLayer)
+	sources := make(map[string]string)
+	for _, layer := range pc.layers(repo, teams, archived) {
+		for key, value := range layer.fields {
+			effective[key] = value
+			sources[key] = layer.name
+		}
+	}
+	p, err := effective.policy()
+	return p, sources, err
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
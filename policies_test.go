@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/go-github/github"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const layeredPolicy = `{
+  "required_status_checks": {"strict": true, "contexts": ["ci/travis"]},
+  "enforce_admins": true,
+  "orgs": {"jcgay": {"required_pull_request_reviews": {"dismiss_stale_reviews": true}}},
+  "topics": {"maven": {"required_status_checks": {"strict": false, "contexts": ["ci/maven"]}}},
+  "teams": {"jcgay/core": {"restrictions": {"users": ["jcgay"], "teams": []}}},
+  "repos": {"jcgay/maven-color": {"enforce_admins": false}}
+}`
+
+func TestPolicyResolvesLevelsFromGlobalToRepository(t *testing.T) {
+	// Given
+	pc, err := parsePolicyConfig([]byte(layeredPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, login, fullName := "maven-color", "jcgay", "jcgay/maven-color"
+	repo := &github.Repository{Name: &name, FullName: &fullName, Owner: &github.User{Login: &login}, Topics: []string{"maven"}}
+
+	// When
+	p, sources, err := pc.resolve(repo, []string{"jcgay/core"}, false)
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RequiredStatusChecks.Strict || p.RequiredStatusChecks.Contexts[0] != "ci/maven" || !p.RequiredPullRequestReviews.DismissStaleReviews || p.EnforceAdmins || p.Restrictions.Users[0] != "jcgay" {
+		t.Errorf("Unexpected resolved policy: %+v", p)
+	}
+	for field, expected := range map[string]string{
+		"required_status_checks":        "topic maven",
+		"required_pull_request_reviews": "org jcgay",
+		"enforce_admins":                "repo jcgay/maven-color",
+		"restrictions":                  "team jcgay/core",
+	} {
+		if sources[field] != expected {
+			t.Errorf("%s should come from %s, got %s", field, expected, sources[field])
+		}
+	}
+}
+
+func TestPolicyRefusesUnknownSettings(t *testing.T) {
+	// When
+	_, err := parsePolicyConfig([]byte(`{"repos": {"jcgay/maven-color": {"enforce_admin": true}}}`))
+
+	// Then
+	if err == nil || err.Error() != "repos jcgay/maven-color: unknown setting enforce_admin" {
+		t.Errorf("Unknown setting should be refused, got %v", err)
+	}
+}
+
+const selectedPolicy = `{
+  "profiles": {
+    "pci": {"required_pull_request_reviews": {"dismiss_stale_reviews": true}, "enforce_admins": true},
+    "library": {"required_status_checks": {"strict": true, "contexts": ["ci/travis"]}}
+  },
+  "rules": [
+    {"match": {"visibility": "private", "topics": ["pci"]}, "profile": "pci"},
+    {"match": {"visibility": "public", "fork": false, "name": "-lib$"}, "profile": "library"}
+  ]
+}`
+
+func TestPolicyIgnoresUnknownTopLevelFields(t *testing.T) {
+	// When
+	pc, err := parsePolicyConfig([]byte(`{"url": "https://api.github.com/repos/jcgay/maven-color/branches/master/protection", "enforce_admins": true}`))
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := pc.global.policy(); err != nil || !p.EnforceAdmins {
+		t.Errorf("Known settings should be read, got %+v and %v", p, err)
+	}
+}
+
+func TestPolicyAppliesProfilesOfMatchingRules(t *testing.T) {
+	// Given
+	pc, err := parsePolicyConfig([]byte(selectedPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, private, public := "jcgay", true, false
+	payments, paymentsName := "payments", "jcgay/payments"
+	colorLib, colorLibName := "color-lib", "jcgay/color-lib"
+	pciRepo := &github.Repository{Name: &payments, FullName: &paymentsName, Owner: &github.User{Login: &login}, Private: &private, Topics: []string{"pci"}}
+	libraryRepo := &github.Repository{Name: &colorLib, FullName: &colorLibName, Owner: &github.User{Login: &login}, Private: &public, Fork: &public}
+
+	// When
+	pciPolicy, pciSources, err := pc.resolve(pciRepo, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libraryPolicy, librarySources, err := pc.resolve(libraryRepo, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	if !pciPolicy.EnforceAdmins || pciPolicy.RequiredPullRequestReviews == nil || pciPolicy.RequiredStatusChecks != nil {
+		t.Errorf("Unexpected pci policy: %+v", pciPolicy)
+	}
+	if pciSources["enforce_admins"] != "rule 1 (profile pci)" {
+		t.Errorf("enforce_admins should come from the pci rule, got %s", pciSources["enforce_admins"])
+	}
+	if libraryPolicy.EnforceAdmins || libraryPolicy.RequiredPullRequestReviews != nil || libraryPolicy.RequiredStatusChecks == nil {
+		t.Errorf("Unexpected library policy: %+v", libraryPolicy)
+	}
+	if librarySources["required_status_checks"] != "rule 2 (profile library)" {
+		t.Errorf("required_status_checks should come from the library rule, got %s", librarySources["required_status_checks"])
+	}
+}
+
+func TestPolicyRefusesInvalidRules(t *testing.T) {
+	for content, expected := range map[string]string{
+		`{"rules": [{"match": {"private": true}, "profile": "pci"}]}`:                          "rules: unknown selector private",
+		`{"rules": [{"match": {"visibility": "internal"}, "profile": "pci"}]}`:                 "rules: visibility must be public or private, got internal",
+		`{"profiles": {"pci": {}}, "rules": [{"match": {"archived": false}, "profile": "x"}]}`: "rule 1: unknown profile x",
+	} {
+		// When
+		_, err := parsePolicyConfig([]byte(content))
+
+		// Then
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s should be refused with %q, got %v", content, expected, err)
+		}
+	}
+}
+
+func TestExplainPrintsWhereSettingsComeFrom(t *testing.T) {
+	// Given
+	pc, err := parsePolicyConfig([]byte(layeredPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gp := &githubProtection{
+		repositoriesService: &TestLeaseMock{protected: map[string]bool{"master": true}},
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^master$")},
+		policies:            pc,
+		repoPolicyPath:      ".github/protector.yml",
+		readFile: func(repo *github.Repository, ref, path string) ([]byte, error) {
+			return []byte("required_status_checks:\n  contexts: [coverage]\n"), nil
+		},
+	}
+	name, login, fullName := "protector", "jcgay", "jcgay/protector"
+	repo := &github.Repository{Name: &name, FullName: &fullName, Owner: &github.User{Login: &login}}
+	out := new(bytes.Buffer)
+
+	// When
+	if err := gp.explain(out, repo, "master"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	for _, expected := range []string{
+		"jcgay/protector master (selected by ^master$)\n",
+		`  required_status_checks: {"strict":true,"contexts":["ci/travis","coverage"]} (global + .github/protector.yml)`,
+		`  required_pull_request_reviews: {"dismiss_stale_reviews":true} (org jcgay)`,
+		"  restrictions: null (default)",
+		"  [required-pull-request-reviews] pull request reviews are not required",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Explanation should contain [%s], got: [%s]", expected, out.String())
+		}
+	}
+}
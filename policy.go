@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ruleProtected            = "branch-protected"
+	ruleStatusChecks         = "required-status-checks"
+	ruleStrictStatusChecks   = "strict-status-checks"
+	ruleStatusCheckContext   = "required-status-check-context"
+	rulePullRequestReviews   = "required-pull-request-reviews"
+	ruleDismissStaleReviews  = "dismiss-stale-reviews"
+	ruleEnforceAdmins        = "enforce-admins"
+	rulePushRestrictions     = "push-restrictions"
+	rulePushRestrictionsUser = "push-restrictions-user"
+	rulePushRestrictionsTeam = "push-restrictions-team"
+	ruleRepoPolicy           = "repository-policy"
+	ruleReportedContext      = "reported-status-check-context"
+	ruleCodeOwnerReviews     = "code-owner-reviews"
+	ruleCodeOwnersFile       = "codeowners-file"
+	ruleCodeOwner            = "codeowner-write-access"
+	ruleApprovingReviews     = "required-approving-review-count"
+	ruleLinearHistory        = "required-linear-history"
+	ruleForcePushes          = "force-pushes"
+	ruleDeletions            = "deletions"
+	ruleSignatures           = "required-signatures"
+
+	ruleGitLabMergeAccess       = "gitlab-merge-access"
+	ruleGitLabForcePush         = "gitlab-allow-force-push"
+	ruleGitLabCodeOwnerApproval = "gitlab-code-owner-approval"
+)
+
+// finding is a gap between the protection of a branch and the expected policy.
+type finding struct {
+	rule    string
+	message string
+}
+
+// policy describes the protection expected on every selected branch.
+// It uses the same format as the GitHub branch protection API.
+type policy struct {
+	RequiredStatusChecks       *statusChecksPolicy `json:"required_status_checks" yaml:"required_status_checks"`
+	RequiredPullRequestReviews *reviewsPolicy      `json:"required_pull_request_reviews" yaml:"required_pull_request_reviews"`
+	EnforceAdmins              bool                `json:"enforce_admins" yaml:"enforce_admins"`
+	Restrictions               *restrictionsPolicy `json:"restrictions" yaml:"restrictions"`
+	RequiredLinearHistory      bool                `json:"required_linear_history" yaml:"required_linear_history"`
+	AllowForcePushes           *bool               `json:"allow_force_pushes" yaml:"allow_force_pushes"`
+	AllowDeletions             *bool               `json:"allow_deletions" yaml:"allow_deletions"`
+	RequiredSignatures         bool                `json:"required_signatures" yaml:"required_signatures"`
+}
+
+type statusChecksPolicy struct {
+	Strict   bool     `json:"strict" yaml:"strict"`
+	Contexts []string `json:"contexts" yaml:"contexts"`
+}
+
+type reviewsPolicy struct {
+	DismissStaleReviews          bool `json:"dismiss_stale_reviews" yaml:"dismiss_stale_reviews"`
+	RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews,omitempty" yaml:"require_code_owner_reviews"`
+	RequiredApprovingReviewCount int  `json:"required_approving_review_count,omitempty" yaml:"required_approving_review_count"`
+}
+
+type restrictionsPolicy struct {
+	Users []string `json:"users" yaml:"users"`
+	Teams []string `json:"teams" yaml:"teams"`
+}
+
+// parseRepoPolicy reads the YAML policy of a repository, unknown settings are refused.
+func parseRepoPolicy(content []byte) (*policy, error) {
+	p := new(policy)
+	if err := yaml.UnmarshalStrict(content, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// merge adds the settings of override to the policy, which is a floor override can't lower:
+// settings can only be added, force pushes and deletions can't be allowed when the policy blocks them,
+// and push restrictions of the policy can't be changed.
+func (p *policy) merge(override *policy) *policy {
+	if p == nil {
+		return override
+	}
+	if override == nil {
+		return p
+	}
+
+	merged := &policy{
+		EnforceAdmins:         p.EnforceAdmins || override.EnforceAdmins,
+		Restrictions:          p.Restrictions,
+		RequiredLinearHistory: p.RequiredLinearHistory || override.RequiredLinearHistory,
+		AllowForcePushes:      mostRestrictive(p.AllowForcePushes, override.AllowForcePushes),
+		AllowDeletions:        mostRestrictive(p.AllowDeletions, override.AllowDeletions),
+		RequiredSignatures:    p.RequiredSignatures || override.RequiredSignatures,
+	}
+	if merged.Restrictions == nil {
+		merged.Restrictions = override.Restrictions
+	}
+	if p.RequiredStatusChecks != nil || override.RequiredStatusChecks != nil {
+		merged.RequiredStatusChecks = &statusChecksPolicy{}
+		for _, checks := range []*statusChecksPolicy{p.RequiredStatusChecks, override.RequiredStatusChecks} {
+			if checks != nil {
+				merged.RequiredStatusChecks.Strict = merged.RequiredStatusChecks.Strict || checks.Strict
+				merged.RequiredStatusChecks.Contexts = append(merged.RequiredStatusChecks.Contexts, missing(checks.Contexts, merged.RequiredStatusChecks.Contexts)...)
+			}
+		}
+	}
+	if p.RequiredPullRequestReviews != nil || override.RequiredPullRequestReviews != nil {
+		merged.RequiredPullRequestReviews = &reviewsPolicy{}
+		for _, reviews := range []*reviewsPolicy{p.RequiredPullRequestReviews, override.RequiredPullRequestReviews} {
+			if reviews != nil {
+				merged.RequiredPullRequestReviews.DismissStaleReviews = merged.RequiredPullRequestReviews.DismissStaleReviews || reviews.DismissStaleReviews
+				merged.RequiredPullRequestReviews.RequireCodeOwnerReviews = merged.RequiredPullRequestReviews.RequireCodeOwnerReviews || reviews.RequireCodeOwnerReviews
+				if reviews.RequiredApprovingReviewCount > merged.RequiredPullRequestReviews.RequiredApprovingReviewCount {
+					merged.RequiredPullRequestReviews.RequiredApprovingReviewCount = reviews.RequiredApprovingReviewCount
+				}
+			}
+		}
+	}
+	return merged
+}
+
+func (p *policy) request() *github.ProtectionRequest {
+	req := &github.ProtectionRequest{}
+	if p == nil {
+		return req
+	}
+
+	req.EnforceAdmins = p.EnforceAdmins
+	if p.RequiredStatusChecks != nil {
+		req.RequiredStatusChecks = &github.RequiredStatusChecks{
+			Strict:   p.RequiredStatusChecks.Strict,
+			Contexts: nonNil(p.RequiredStatusChecks.Contexts),
+		}
+	}
+	if p.RequiredPullRequestReviews != nil {
+		req.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcementRequest{
+			DismissStaleReviews: p.RequiredPullRequestReviews.DismissStaleReviews,
+		}
+	}
+	if p.Restrictions != nil {
+		req.Restrictions = &github.BranchRestrictionsRequest{
+			Users: nonNil(p.Restrictions.Users),
+			Teams: nonNil(p.Restrictions.Teams),
+		}
+	}
+	return req
+}
+
+// requestOver adds the policy to the current protection of a branch, the settings the policy doesn't ask for are kept.
+// Push restrictions are a ceiling: they are kept to the users and teams both the branch and the policy allow.
+func (p *policy) requestOver(current *github.Protection) *github.ProtectionRequest {
+	req := protectionRequest(current)
+	if p == nil {
+		return req
+	}
+
+	req.EnforceAdmins = req.EnforceAdmins || p.EnforceAdmins
+	if checks := p.RequiredStatusChecks; checks != nil {
+		if req.RequiredStatusChecks == nil {
+			req.RequiredStatusChecks = &github.RequiredStatusChecks{Contexts: []string{}}
+		}
+		req.RequiredStatusChecks.Strict = req.RequiredStatusChecks.Strict || checks.Strict
+		req.RequiredStatusChecks.Contexts = append(req.RequiredStatusChecks.Contexts, missing(checks.Contexts, req.RequiredStatusChecks.Contexts)...)
+	}
+	if reviews := p.RequiredPullRequestReviews; reviews != nil {
+		if req.RequiredPullRequestReviews == nil {
+			req.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcementRequest{}
+		}
+		req.RequiredPullRequestReviews.DismissStaleReviews = req.RequiredPullRequestReviews.DismissStaleReviews || reviews.DismissStaleReviews
+	}
+	if restrictions := p.Restrictions; restrictions != nil {
+		if req.Restrictions == nil {
+			req.Restrictions = &github.BranchRestrictionsRequest{Users: nonNil(restrictions.Users), Teams: nonNil(restrictions.Teams)}
+		} else {
+			req.Restrictions.Users = allowed(req.Restrictions.Users, restrictions.Users)
+			req.Restrictions.Teams = allowed(req.Restrictions.Teams, restrictions.Teams)
+		}
+	}
+	return req
+}
+
+// mostRestrictive blocks an action when one of the values blocks it.
+func mostRestrictive(values ...*bool) *bool {
+	var result *bool
+	for _, value := range values {
+		if value != nil && (result == nil || !*value) {
+			result = value
+		}
+	}
+	return result
+}
+
+// settings returns the settings of the policy the vendored request can't hold.
+func (p *policy) settings() *protectionSettings {
+	settings := &protectionSettings{}
+	if p == nil {
+		return settings
+	}
+
+	settings.RequiredLinearHistory = p.RequiredLinearHistory
+	settings.RequiredSignatures = p.RequiredSignatures
+	settings.AllowForcePushes = p.AllowForcePushes != nil && *p.AllowForcePushes
+	settings.AllowDeletions = p.AllowDeletions != nil && *p.AllowDeletions
+	if reviews := p.RequiredPullRequestReviews; reviews != nil {
+		settings.RequireCodeOwnerReviews = reviews.RequireCodeOwnerReviews
+		settings.RequiredApprovingReviewCount = reviews.RequiredApprovingReviewCount
+	}
+	return settings
+}
+
+// settingsOver adds the settings of the policy to the current settings of a branch.
+func (p *policy) settingsOver(current *protectionSettings) *protectionSettings {
+	if current == nil {
+		return p.settings()
+	}
+	settings := *current
+	if p == nil {
+		return &settings
+	}
+
+	settings.RequiredLinearHistory = settings.RequiredLinearHistory || p.RequiredLinearHistory
+	settings.RequiredSignatures = settings.RequiredSignatures || p.RequiredSignatures
+	if p.AllowForcePushes != nil {
+		settings.AllowForcePushes = settings.AllowForcePushes && *p.AllowForcePushes
+	}
+	if p.AllowDeletions != nil {
+		settings.AllowDeletions = settings.AllowDeletions && *p.AllowDeletions
+	}
+	if reviews := p.RequiredPullRequestReviews; reviews != nil {
+		settings.RequireCodeOwnerReviews = settings.RequireCodeOwnerReviews || reviews.RequireCodeOwnerReviews
+		if reviews.RequiredApprovingReviewCount > settings.RequiredApprovingReviewCount {
+			settings.RequiredApprovingReviewCount = reviews.RequiredApprovingReviewCount
+		}
+	}
+	return &settings
+}
+
+// check lists every setting of the policy that is missing from the branch protection.
+// Settings enabled on the branch but not asked by the policy are accepted. The settings
+// missing from the vendored types are only checked when they could be read.
+func (p *policy) check(protection *github.Protection, settings *protectionSettings) []finding {
+	var findings []finding
+	if p == nil {
+		return findings
+	}
+
+	if p.RequiredStatusChecks != nil {
+		actual := protection.RequiredStatusChecks
+		if actual == nil {
+			findings = append(findings, finding{ruleStatusChecks, "status checks are not required"})
+		} else {
+			if p.RequiredStatusChecks.Strict && !actual.Strict {
+				findings = append(findings, finding{ruleStrictStatusChecks, "branches are not required to be up to date before merging"})
+			}
+			for _, context := range missing(p.RequiredStatusChecks.Contexts, actual.Contexts) {
+				findings = append(findings, finding{ruleStatusCheckContext, fmt.Sprintf("status check %s is not required", context)})
+			}
+		}
+	}
+
+	if p.RequiredPullRequestReviews != nil {
+		actual := protection.RequiredPullRequestReviews
+		if actual == nil {
+			findings = append(findings, finding{rulePullRequestReviews, "pull request reviews are not required"})
+		} else if p.RequiredPullRequestReviews.DismissStaleReviews && !actual.DismissStaleReviews {
+			findings = append(findings, finding{ruleDismissStaleReviews, "stale reviews are not dismissed"})
+		}
+	}
+
+	if p.EnforceAdmins && (protection.EnforceAdmins == nil || !protection.EnforceAdmins.Enabled) {
+		findings = append(findings, finding{ruleEnforceAdmins, "protection is not enforced for administrators"})
+	}
+
+	if p.Restrictions != nil {
+		actual := protection.Restrictions
+		if actual == nil {
+			findings = append(findings, finding{rulePushRestrictions, "push access is not restricted"})
+		} else {
+			users := make([]string, 0, len(actual.Users))
+			for _, user := range actual.Users {
+				users = append(users, user.GetLogin())
+			}
+			for _, user := range extra(users, p.Restrictions.Users) {
+				findings = append(findings, finding{rulePushRestrictionsUser, fmt.Sprintf("user %s can push", user)})
+			}
+			teams := make([]string, 0, len(actual.Teams))
+			for _, team := range actual.Teams {
+				teams = append(teams, team.GetSlug())
+			}
+			for _, team := range extra(teams, p.Restrictions.Teams) {
+				findings = append(findings, finding{rulePushRestrictionsTeam, fmt.Sprintf("team %s can push", team)})
+			}
+		}
+	}
+
+	if settings != nil {
+		findings = append(findings, p.checkSettings(protection, settings)...)
+	}
+	return findings
+}
+
+func (p *policy) checkSettings(protection *github.Protection, settings *protectionSettings) []finding {
+	var findings []finding
+	if reviews := p.RequiredPullRequestReviews; reviews != nil && protection.RequiredPullRequestReviews != nil {
+		if reviews.RequiredApprovingReviewCount > settings.RequiredApprovingReviewCount {
+			findings = append(findings, finding{ruleApprovingReviews, fmt.Sprintf("%d approving reviews are required instead of %d", settings.RequiredApprovingReviewCount, reviews.RequiredApprovingReviewCount)})
+		}
+		if reviews.RequireCodeOwnerReviews && !settings.RequireCodeOwnerReviews {
+			findings = append(findings, finding{ruleCodeOwnerReviews, "code owner reviews are not required"})
+		}
+	}
+	if p.RequiredLinearHistory && !settings.RequiredLinearHistory {
+		findings = append(findings, finding{ruleLinearHistory, "linear history is not required"})
+	}
+	if p.AllowForcePushes != nil && !*p.AllowForcePushes && settings.AllowForcePushes {
+		findings = append(findings, finding{ruleForcePushes, "force pushes are allowed"})
+	}
+	if p.AllowDeletions != nil && !*p.AllowDeletions && settings.AllowDeletions {
+		findings = append(findings, finding{ruleDeletions, "branch can be deleted"})
+	}
+	if p.RequiredSignatures && !settings.RequiredSignatures {
+		findings = append(findings, finding{ruleSignatures, "signed commits are not required"})
+	}
+	return findings
+}
+
+// missing returns the values expected but not found in actual.
+func missing(expected, actual []string) []string {
+	present := make(map[string]bool, len(actual))
+	for _, value := range actual {
+		present[value] = true
+	}
+
+	result := make([]string, 0)
+	for _, value := range expected {
+		if !present[value] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// extra returns the values found in actual but not allowed.
+func extra(actual, allowed []string) []string {
+	return missing(actual, allowed)
+}
+
+// allowed returns the values found in actual that are allowed.
+func allowed(actual, allowed []string) []string {
+	return missing(actual, extra(actual, allowed))
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"testing"
+)
+
+func TestPolicyCheckReportsMissingSettings(t *testing.T) {
+	// Given
+	p := &policy{
+		RequiredStatusChecks: &statusChecksPolicy{Contexts: []string{"ci/travis", "coverage"}},
+		EnforceAdmins:        true,
+	}
+	protection := &github.Protection{
+		RequiredStatusChecks: &github.RequiredStatusChecks{Contexts: []string{"ci/travis"}},
+		EnforceAdmins:        &github.AdminEnforcement{Enabled: true},
+	}
+
+	// When
+	findings := p.check(protection, nil)
+
+	// Then
+	if len(findings) != 1 || findings[0].rule != ruleStatusCheckContext {
+		t.Errorf("Only the coverage status check should be missing, got: %v", findings)
+	}
+}
+
+func TestRepoPolicyCantLowerPolicy(t *testing.T) {
+	// Given
+	org := &policy{
+		RequiredStatusChecks: &statusChecksPolicy{Strict: true, Contexts: []string{"ci/travis"}},
+		Restrictions:         &restrictionsPolicy{Teams: []string{"admins"}},
+	}
+	repo, err := parseRepoPolicy([]byte(`
+required_status_checks:
+  strict: false
+  contexts: [coverage]
+enforce_admins: true
+restrictions:
+  users: [jcgay]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When
+	merged := org.merge(repo)
+
+	// Then
+	if !merged.RequiredStatusChecks.Strict || fmt.Sprint(merged.RequiredStatusChecks.Contexts) != "[ci/travis coverage]" {
+		t.Errorf("Status checks should add up, got %+v", merged.RequiredStatusChecks)
+	}
+	if !merged.EnforceAdmins {
+		t.Error("Repository policy should be able to enforce protection for administrators")
+	}
+	if len(merged.Restrictions.Users) != 0 || merged.Restrictions.Teams[0] != "admins" {
+		t.Errorf("Push restrictions of the policy can't be changed, got %+v", merged.Restrictions)
+	}
+}
+
+func TestRepoPolicyRefusesUnknownSettings(t *testing.T) {
+	// When
+	_, err := parseRepoPolicy([]byte("required_status_check:\n  contexts: [ci/travis]\n"))
+
+	// Then
+	if err == nil {
+		t.Error("Unknown setting should be refused")
+	}
+}
+
+func TestPolicyRequestOverKeepsCurrentProtection(t *testing.T) {
+	// Given
+	p := &policy{
+		RequiredStatusChecks: &statusChecksPolicy{Strict: true, Contexts: []string{"ci"}},
+		EnforceAdmins:        true,
+	}
+	user, other := "jcgay", "someone"
+	current := &github.Protection{
+		RequiredStatusChecks: &github.RequiredStatusChecks{Contexts: []string{"lint"}},
+		Restrictions: &github.BranchRestrictions{
+			Users: []*github.User{{Login: &user}, {Login: &other}},
+		},
+	}
+
+	// When
+	req := p.requestOver(current)
+
+	// Then
+	if !req.EnforceAdmins {
+		t.Error("Protection should be enforced for administrators")
+	}
+	if !req.RequiredStatusChecks.Strict || fmt.Sprint(req.RequiredStatusChecks.Contexts) != "[lint ci]" {
+		t.Errorf("Status checks of the branch should be kept, got %+v", req.RequiredStatusChecks)
+	}
+	if req.Restrictions == nil || fmt.Sprint(req.Restrictions.Users) != "[jcgay someone]" {
+		t.Errorf("Push restrictions of the branch should be kept, got %+v", req.Restrictions)
+	}
+}
+
+func TestPolicyRequestOverLimitsPushRestrictions(t *testing.T) {
+	// Given
+	p := &policy{Restrictions: &restrictionsPolicy{Users: []string{"jcgay", "admin"}}}
+	user, other := "jcgay", "someone"
+	current := &github.Protection{
+		Restrictions: &github.BranchRestrictions{
+			Users: []*github.User{{Login: &user}, {Login: &other}},
+		},
+	}
+
+	// When
+	req := p.requestOver(current)
+
+	// Then
+	if fmt.Sprint(req.Restrictions.Users) != "[jcgay]" {
+		t.Errorf("Only users allowed by the branch and the policy should push, got %+v", req.Restrictions.Users)
+	}
+}
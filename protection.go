@@ -7,11 +7,13 @@ import (
 	"io"
 	"net/http"
 	"regexp"
+	"time"
 )
 
 type protection interface {
 	protect(repo *github.Repository)
 	free(repo *github.Repository)
+	report(r *result)
 }
 
 type githubProtection struct {
@@ -19,41 +21,166 @@ type githubProtection struct {
 	branchPatterns      []*regexp.Regexp
 	successOutput       io.Writer
 	failureOutput       io.Writer
+	policy              *policy
+	listeners           []listener
+	leases              *leaseStore
+	leaseDuration       time.Duration
+	audit               *auditLog
+	policies            *policyConfig
+	repoPolicyPath      string
+	readFile            func(repo *github.Repository, ref, path string) ([]byte, error)
+	repoTeams           func(repo *github.Repository) ([]string, error)
+	repoArchived        func(repo *github.Repository) (bool, error)
+	commitContexts      func(repo *github.Repository, branch string, commits int) ([][]string, error)
+	hostSettings        func(repo *github.Repository, branch string) ([]finding, error)
+	discoverCommits     int
+	applyContexts       bool
+	verifyCommits       int
+	strictContexts      bool
+	codeOwners          codeOwnersHost
+	supported           func(p *policy) *policy
+	skipLeased          bool
+	dryrun              bool
+	planned             map[string]bool
 }
 
-type success string
-type failure string
-
-func (gp *githubProtection) process(repo *github.Repository, modify func(*github.Branch) (success, failure)) {
+func (gp *githubProtection) process(repo *github.Repository, modify func(*github.Branch) *result) {
 	if (*repo.Permissions)["admin"] == false {
-		fmt.Fprintf(gp.failureOutput, "%s: you don't have admin rights to modify this repository\n", *repo.FullName)
+		gp.report(&result{
+			repo:    repo,
+			status:  statusNoAdmin,
+			message: fmt.Sprintf("%s: you don't have admin rights to modify this repository", *repo.FullName),
+		})
 		return
 	}
 
 	branches, err := gp.filterBranches(repo)
 	if err != nil {
-		fmt.Fprint(gp.failureOutput, err)
+		gp.report(&result{repo: repo, status: statusFailed, message: err.Error()})
 	}
 
 	for _, branch := range branches {
-		success, failure := modify(branch)
-		if failure != "" {
-			fmt.Fprintln(gp.failureOutput, failure)
-		} else {
-			fmt.Fprintln(gp.successOutput, success)
+		if gp.planned != nil && !gp.planned[plannedKey(repo, *branch.Name)] {
+			continue
 		}
+		gp.report(modify(branch))
+	}
+}
+
+func (gp *githubProtection) report(r *result) {
+	if r.status.failed() {
+		fmt.Fprintln(gp.failureOutput, r.message)
+	} else {
+		fmt.Fprintln(gp.successOutput, r.message)
+	}
+
+	for _, l := range gp.listeners {
+		l.notify(r)
 	}
 }
 
 func (gp *githubProtection) protect(repo *github.Repository) {
-	gp.process(repo, func(branch *github.Branch) (success, failure) {
-		return gp.lock(repo, *branch.Name)
+	var p *policy
+	resolved := false
+	gp.process(repo, func(branch *github.Branch) *result {
+		if !resolved {
+			p, resolved = gp.policyFor(repo), true
+		}
+		return gp.lockWithDiscovery(repo, branch, p)
 	})
 }
 
+// policyFor resolves the policy of a repository without the settings the git host can't enforce.
+func (gp *githubProtection) policyFor(repo *github.Repository) *policy {
+	p := gp.mergedPolicy(repo)
+	if gp.supported != nil {
+		return gp.supported(p)
+	}
+	return p
+}
+
+// mergedPolicy merges the policy file of a repository, read from its default branch, with the policy resolved for
+// the repository. Problems are reported and the policy is then used without the file.
+func (gp *githubProtection) mergedPolicy(repo *github.Repository) *policy {
+	p, _, err := gp.centralPolicy(repo)
+	if err != nil {
+		gp.report(&result{repo: repo, status: statusFailed, message: fmt.Sprintf("%s: %v", *repo.FullName, err)})
+		return gp.policy
+	}
+
+	override, err := gp.repoPolicy(repo)
+	if e, ok := err.(*invalidRepoPolicyError); ok {
+		gp.report(&result{
+			repo:     repo,
+			status:   statusFailed,
+			message:  fmt.Sprintf("%s: %v", *repo.FullName, e),
+			findings: []finding{{ruleRepoPolicy, e.Error()}},
+		})
+		return p
+	}
+	if err != nil {
+		gp.report(&result{repo: repo, status: statusFailed, message: fmt.Sprintf("%s: %v", *repo.FullName, err)})
+		return p
+	}
+	return p.merge(override)
+}
+
+// centralPolicy resolves the -policy configuration for a repository, with the level each setting comes from.
+func (gp *githubProtection) centralPolicy(repo *github.Repository) (*policy, map[string]string, error) {
+	if gp.policies == nil {
+		return gp.policy, map[string]string{}, nil
+	}
+
+	var teams []string
+	if gp.policies.needsTeams() && gp.repoTeams != nil {
+		var err error
+		if teams, err = gp.repoTeams(repo); err != nil {
+			return nil, nil, fmt.Errorf("can't list teams to resolve the policy: %v", err)
+		}
+	}
+	archived := false
+	if gp.policies.needsArchived() && gp.repoArchived != nil {
+		var err error
+		if archived, err = gp.repoArchived(repo); err != nil {
+			return nil, nil, fmt.Errorf("can't tell if the repository is archived to resolve the policy: %v", err)
+		}
+	}
+	return gp.policies.resolve(repo, teams, archived)
+}
+
+type invalidRepoPolicyError struct {
+	path string
+	err  error
+}
+
+func (e *invalidRepoPolicyError) Error() string {
+	return fmt.Sprintf("%s is invalid: %v", e.path, e.err)
+}
+
+// repoPolicy reads the policy file of a repository, it returns nil when there is none.
+func (gp *githubProtection) repoPolicy(repo *github.Repository) (*policy, error) {
+	if gp.readFile == nil || gp.repoPolicyPath == "" {
+		return nil, nil
+	}
+
+	content, err := gp.readFile(repo, repo.GetDefaultBranch(), gp.repoPolicyPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't read %s: %v", gp.repoPolicyPath, err)
+	}
+	if content == nil {
+		return nil, nil
+	}
+
+	override, err := parseRepoPolicy(content)
+	if err != nil {
+		return nil, &invalidRepoPolicyError{gp.repoPolicyPath, err}
+	}
+	return override, nil
+}
+
 func (gp *githubProtection) free(repo *github.Repository) {
-	gp.process(repo, func(branch *github.Branch) (success, failure) {
-		return gp.unlock(repo, *branch.Name)
+	gp.process(repo, func(branch *github.Branch) *result {
+		return gp.unlock(repo, branch)
 	})
 }
 
@@ -82,56 +209,173 @@ func (gp *githubProtection) filterBranches(repo *github.Repository) ([]*github.B
 	return result, nil
 }
 
-func withRepo(msg string, repo *github.Repository, branch *github.Branch) string {
-	return fmt.Sprintf("%s: %s %s", *repo.FullName, *branch.Name, msg)
+// isProtected reads the protection flag from the branch listing, the branch is only fetched again
+// when the listing didn't include it.
+func (gp *githubProtection) isProtected(repo *github.Repository, branch *github.Branch) (bool, error) {
+	if branch.Protected != nil {
+		return *branch.Protected, nil
+	}
+
+	branch, _, err := gp.repositoriesService.GetBranch(context.TODO(), *repo.Owner.Login, *repo.Name, *branch.Name)
+	if err != nil {
+		return false, err
+	}
+	return branch.GetProtected(), nil
+}
+
+// leased reports a branch freed with -for until its lease expires, it is then left as is. Only runs with
+// -interval skip leased branches, other runs change them as asked.
+func (gp *githubProtection) leased(repo *github.Repository, branchName string) *result {
+	if !gp.skipLeased {
+		return nil
+	}
+	l, err := gp.leases.active(*repo.Owner.Login, *repo.Name, branchName, time.Now())
+	if err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error())
+	}
+	if l != nil {
+		return newResult(repo, branchName, statusAlreadyFree, fmt.Sprintf("is leased until %s", l.Expires.Format(time.RFC3339)))
+	}
+	return nil
 }
 
-func (gp *githubProtection) lock(repo *github.Repository, branchName string) (success, failure) {
-	branch, _, err := gp.repositoriesService.GetBranch(context.TODO(), *repo.Owner.Login, *repo.Name, branchName)
+func (gp *githubProtection) lock(repo *github.Repository, branch *github.Branch, p *policy) *result {
+	branchName := *branch.Name
+	if r := gp.leased(repo, branchName); r != nil {
+		return r
+	}
+	protected, err := gp.isProtected(repo, branch)
 	if err != nil {
-		return "", failure(withRepo(err.Error(), repo, branch))
+		return newResult(repo, branchName, statusFailed, err.Error())
+	}
+
+	p, problems, err := gp.withCodeOwners(repo, branchName, p)
+	if err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error())
+	}
+	note := ""
+	if len(problems) > 0 {
+		note = ", code owner reviews can't be required"
+	}
+
+	var current *github.Protection
+	var settings *protectionSettings
+	var findings []finding
+	if protected {
+		if current, settings, findings, err = gp.inspect(repo, branchName, p); err != nil {
+			return newResult(repo, branchName, statusFailed, err.Error(), problems...)
+		}
+		if len(findings) == 0 {
+			return newResult(repo, branchName, statusAlreadyProtected, "is already protected"+note, problems...)
+		}
+	} else {
+		findings = []finding{{ruleProtected, "branch is not protected"}}
+	}
+	findings = append(findings, problems...)
+
+	warning, err := gp.verifyContexts(repo, branchName, p, current)
+	if err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error(), findings...)
+	}
+	if warning != "" && gp.strictContexts {
+		return newResult(repo, branchName, statusFailed, warning+", protection is not changed", append(findings, finding{ruleReportedContext, warning})...)
 	}
+	if warning != "" {
+		warning = ", warning: " + warning
+	}
+	warning += note
 
-	if *branch.Protected {
-		return success(withRepo("is already protected", repo, branch)), ""
+	if gp.dryrun {
+		if protected {
+			return newResult(repo, branchName, statusToUpdate, "protection will be updated"+warning, findings...)
+		}
+		return newResult(repo, branchName, statusToProtect, "will be set to protected"+warning, findings...)
 	}
 
-	if dryrun {
-		return success(withRepo("will be set to protected", repo, branch)), ""
+	preq, update := p.request(), p.settings()
+	if protected {
+		preq, update = p.requestOver(current), p.settingsOver(settings)
+	}
+	if err := gp.updateProtection(repo, branchName, preq, update); err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error(), findings...)
 	}
 
-	activateProtection := true
-	branch.Protected = &activateProtection
-	protectionReq := &github.ProtectionRequest{
-		RequiredStatusChecks: nil,
-		Restrictions:         nil,
+	if protected {
+		return newResult(repo, branchName, statusUpdated, "protection is now up to date"+warning, problems...)
 	}
-	if _, _, err := gp.repositoriesService.UpdateBranchProtection(context.TODO(), *repo.Owner.Login, *repo.Name, *branch.Name, protectionReq); err != nil {
-		return "", failure(withRepo(err.Error(), repo, branch))
+	return newResult(repo, branchName, statusProtected, "is now protected"+warning, problems...)
+}
+
+// inspect compares the current protection of a branch with the policy.
+func (gp *githubProtection) inspect(repo *github.Repository, branchName string, p *policy) (*github.Protection, *protectionSettings, []finding, error) {
+	if p == nil {
+		return nil, nil, nil, nil
 	}
 
-	return success(withRepo("is now protected", repo, branch)), ""
+	protection, settings, err := gp.getProtection(repo, branchName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	findings := p.check(protection, settings)
+	if gp.hostSettings != nil {
+		gaps, err := gp.hostSettings(repo, branchName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		findings = append(findings, gaps...)
+	}
+	return protection, settings, findings, nil
 }
 
-func (gp *githubProtection) unlock(repo *github.Repository, branchName string) (success, failure) {
-	branch, _, err := gp.repositoriesService.GetBranch(context.TODO(), *repo.Owner.Login, *repo.Name, branchName)
+func (gp *githubProtection) unlock(repo *github.Repository, branch *github.Branch) *result {
+	branchName := *branch.Name
+	if r := gp.leased(repo, branchName); r != nil {
+		return r
+	}
+	protected, err := gp.isProtected(repo, branch)
 	if err != nil {
-		return "", failure(withRepo(err.Error(), repo, branch))
+		return newResult(repo, branchName, statusFailed, err.Error())
 	}
 
-	if !*branch.Protected {
-		return success(withRepo("is already unprotected", repo, branch)), ""
+	if !protected {
+		return newResult(repo, branchName, statusAlreadyFree, "is already unprotected")
 	}
 
-	if dryrun {
-		return success(withRepo("will be freed", repo, branch)), ""
+	if gp.leaseDuration > 0 {
+		return gp.lease(repo, branchName)
 	}
 
-	if _, err := gp.repositoriesService.RemoveBranchProtection(context.TODO(), *repo.Owner.Login, *repo.Name, *branch.Name); err != nil {
-		return "", failure(withRepo(err.Error(), repo, branch))
+	if gp.dryrun {
+		return newResult(repo, branchName, statusToFree, "will be freed")
 	}
 
-	return success(withRepo("is now free", repo, branch)), ""
+	if _, err := gp.repositoriesService.RemoveBranchProtection(context.TODO(), *repo.Owner.Login, *repo.Name, branchName); err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error())
+	}
+
+	return newResult(repo, branchName, statusFreed, "is now free")
+}
+
+// lease frees a branch for a limited time, its protection is recorded before being removed
+// so that the branch can't stay free if the removal succeeds but the lease can't be written.
+func (gp *githubProtection) lease(repo *github.Repository, branchName string) *result {
+	if gp.dryrun {
+		return newResult(repo, branchName, statusToFree, fmt.Sprintf("will be freed for %s", gp.leaseDuration))
+	}
+
+	protection, settings, err := gp.getProtection(repo, branchName)
+	if err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error())
+	}
+	expires := time.Now().Add(gp.leaseDuration)
+	if err := gp.leases.add(&lease{Owner: *repo.Owner.Login, Repository: *repo.Name, Branch: branchName, Expires: expires, Protection: protection, Settings: settings}); err != nil {
+		return newResult(repo, branchName, statusFailed, fmt.Sprintf("lease can't be recorded: %v", err))
+	}
+
+	if _, err := gp.repositoriesService.RemoveBranchProtection(context.TODO(), *repo.Owner.Login, *repo.Name, branchName); err != nil {
+		return newResult(repo, branchName, statusFailed, err.Error())
+	}
+	return newResult(repo, branchName, statusFreed, fmt.Sprintf("is now free until %s", expires.Format(time.RFC3339)))
 }
 
 func (gp *githubProtection) accept(branchName string) bool {
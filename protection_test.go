@@ -6,6 +6,7 @@ import (
 	"github.com/google/go-github/github"
 	"net/http"
 	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -27,6 +28,10 @@ func (p *TestProtectRepositoryMock) ListBranches(ctx context.Context, owner stri
 	return []*github.Branch{branch1}, resp, nil
 }
 
+func (p *TestProtectRepositoryMock) GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error) {
+	return &github.Protection{}, nil, nil
+}
+
 func (p *TestProtectRepositoryMock) UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error) {
 	return nil, nil, nil
 }
@@ -79,3 +84,103 @@ func TestProtectRepository(t *testing.T) {
 		t.Errorf("The repository should be locked with a success message, got: [%s]", success.String())
 	}
 }
+
+type TestListedProtectionMock struct {
+	TestProtectRepositoryMock
+	getBranchCalls int
+}
+
+func (p *TestListedProtectionMock) ListBranches(ctx context.Context, owner string, repo string, opt *github.ListOptions) ([]*github.Branch, *github.Response, error) {
+	name := "branch-1"
+	protected := false
+	resp := &github.Response{
+		Response: &http.Response{
+			StatusCode: 200,
+		},
+	}
+	return []*github.Branch{{Name: &name, Protected: &protected}}, resp, nil
+}
+
+func (p *TestListedProtectionMock) GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error) {
+	p.getBranchCalls++
+	return p.TestProtectRepositoryMock.GetBranch(ctx, owner, repo, branchName)
+}
+
+func TestProtectUsesProtectionFlagFromBranchListing(t *testing.T) {
+	// Given
+	success := new(bytes.Buffer)
+	mock := &TestListedProtectionMock{}
+	gp := githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       success,
+		failureOutput:       new(bytes.Buffer),
+	}
+
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	repository := &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+
+	// When
+	gp.protect(repository)
+
+	// Then
+	if mock.getBranchCalls != 0 {
+		t.Errorf("Branch should not be fetched again, got %d calls", mock.getBranchCalls)
+	}
+
+	if success.String() != "jcgay/maven-color: branch-1 is now protected\n" {
+		t.Errorf("The repository should be locked with a success message, got: [%s]", success.String())
+	}
+}
+
+func TestProtectReportsInvalidRepoPolicy(t *testing.T) {
+	// Given
+	success := new(bytes.Buffer)
+	failure := new(bytes.Buffer)
+	collector := new(reportCollector)
+	gp := githubProtection{
+		repositoriesService: &TestProtectRepositoryMock{},
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       success,
+		failureOutput:       failure,
+		listeners:           []listener{collector},
+		repoPolicyPath:      ".github/protector.yml",
+		readFile: func(repo *github.Repository, ref, path string) ([]byte, error) {
+			return []byte("enforce_admins: maybe\n"), nil
+		},
+	}
+
+	repoName := "maven-color"
+	login := "jcgay"
+	repoFullName := login + "/" + repoName
+	repository := &github.Repository{
+		Name:     &repoName,
+		FullName: &repoFullName,
+		Owner:    &github.User{Login: &login},
+		Permissions: &map[string]bool{
+			"admin": true,
+		}}
+
+	// When
+	gp.protect(repository)
+
+	// Then
+	if !strings.HasPrefix(failure.String(), "jcgay/maven-color: .github/protector.yml is invalid:") {
+		t.Errorf("Invalid repository policy should be reported, got: [%s]", failure.String())
+	}
+	results := collector.sorted()
+	if len(results) != 2 || len(results[0].findings) != 1 || results[0].findings[0].rule != ruleRepoPolicy {
+		t.Errorf("Invalid repository policy should be a finding of the repository, got %+v", results)
+	}
+	if success.String() != "jcgay/maven-color: branche-1 is now protected\n" {
+		t.Errorf("Branches should still be protected with the policy, got: [%s]", success.String())
+	}
+}
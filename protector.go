@@ -5,15 +5,33 @@ import (
 	"flag"
 	"fmt"
 	"github.com/google/go-github/github"
-	"golang.org/x/oauth2"
 	currentVersion "github.com/jcgay/protector/version"
+	"golang.org/x/oauth2"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	banner = "protector - %s (%s)\n"
+	usage  = `
+Usage: protector [command] [flags]
+
+Commands:
+  protect	protect selected branches (default)
+  free		remove protection of selected branches (same as -free)
+  report	write a compliance report of selected branches (needs -html and/or -markdown)
+  expire	restore protection of branches freed with -for once their lease expired
+  verify-log	check that the audit log has not been tampered with (verify-log [flags] [head hash])
+  explain	print the effective policy of a branch and where its settings come from (explain [flags] owner/repo branch)
+
+Flags:
+`
 )
 
 var (
@@ -24,6 +42,34 @@ var (
 	protectBranches     []*regexp.Regexp
 	protectRepositories stringsFlag
 	orgs                stringsFlag
+	teams               stringsFlag
+	allOrgs             bool
+	excludedOrgs        []*regexp.Regexp
+	interval            time.Duration
+	metricsAddr         string
+	policyFile          string
+	htmlFile            string
+	markdownFile        string
+	output              string
+	cacheDir            string
+	cacheMaxAge         time.Duration
+	noCache             bool
+	backend             string
+	useRules            bool
+	providerName        string
+	apiURL              string
+	gitlab              gitlabSettings
+	leaseDuration       time.Duration
+	leaseFile           string
+	maxChanges          int
+	confirmAbove        int
+	assumeYes           bool
+	auditFile           string
+	repoPolicyPath      string
+	discoverCommits     int
+	applyContexts       bool
+	verifyCommits       int
+	strictContexts      bool
 )
 
 type stringsFlag []string
@@ -38,6 +84,7 @@ func (s *stringsFlag) Set(value string) error {
 
 type repositoriesService interface {
 	GetBranch(ctx context.Context, owner, repo, branchName string) (*github.Branch, *github.Response, error)
+	GetBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Protection, *github.Response, error)
 	ListBranches(ctx context.Context, owner string, repo string, opt *github.ListOptions) ([]*github.Branch, *github.Response, error)
 	UpdateBranchProtection(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest) (*github.Protection, *github.Response, error)
 	RemoveBranchProtection(ctx context.Context, owner, repo, branch string) (*github.Response, error)
@@ -45,40 +92,220 @@ type repositoriesService interface {
 
 func main() {
 	// parse flags
-	flag.StringVar(&ghToken, "token", "", "GitHub API token")
+	flag.StringVar(&ghToken, "token", "", "API token")
+	flag.StringVar(&providerName, "provider", "github", "git host: github, gitlab, gitea or bitbucket")
+	flag.StringVar(&apiURL, "url", "", "API URL of a self-hosted git host (ex: https://gitlab.example.com/api/v4)")
 	flag.BoolVar(&dryrun, "dry-run", false, "do not make any changes, just print out what would have been done")
 	flag.BoolVar(&version, "version", false, "print version and exit")
 	flag.BoolVar(&version, "v", false, "print version and exit (shorthand)")
 	flag.BoolVar(&unprotect, "free", false, "remove branch protection")
+	flag.DurationVar(&leaseDuration, "for", 0, "remove branch protection for this duration only, it is restored by expire or -interval (ex: 2h)")
+	flag.StringVar(&leaseFile, "leases", defaultLeaseFile(), "file where branches freed with -for are recorded")
+	flag.StringVar(&auditFile, "audit-log", defaultAuditLog(), "JSONL file where every protection change is recorded (empty to disable)")
+	flag.IntVar(&maxChanges, "max-changes", 0, "abort without changing anything when more branches would be changed (0 for no limit)")
+	flag.IntVar(&confirmAbove, "confirm-above", 10, "ask for confirmation before freeing more branches")
+	flag.BoolVar(&assumeYes, "yes", false, "do not ask for confirmation")
 	flag.Var(&protectRepositories, "repos", "repositories fullname to protect (ex: jcgay/maven-color)")
 	flag.Var(&orgs, "orgs", "organizations name to protect")
+	flag.BoolVar(&allOrgs, "all-orgs", false, "protect repositories of every organization the token can administer")
+	flag.Var(&teams, "teams", "teams whose repositories to protect (ex: my-org/my-team)")
+	flag.DurationVar(&interval, "interval", 0, "keep running and process repositories again after this delay (ex: 1h)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "address to expose Prometheus metrics on /metrics (ex: :9090)")
+
+	flag.StringVar(&repoPolicyPath, "repo-policy", ".github/protector.yml", "YAML file read from the default branch of each repository, adding to the -policy settings (empty to disable)")
+	flag.IntVar(&discoverCommits, "discover-contexts", 0, "suggest the status check contexts reported by every one of the last N commits of a branch (0 to disable)")
+	flag.BoolVar(&applyContexts, "apply-contexts", false, "require the contexts found by -discover-contexts instead of suggesting them")
+	flag.IntVar(&verifyCommits, "verify-contexts", 10, "warn when required status check contexts were not reported by the last N commits of a branch (0 to disable)")
+	flag.BoolVar(&strictContexts, "strict-contexts", false, "do not change a branch when required status check contexts were not reported by its last commits")
+	flag.StringVar(&policyFile, "policy", "", "JSON file describing the expected protection, using the GitHub branch protection API format")
+	flag.StringVar(&htmlFile, "html", "", "HTML file to write the report to")
+	flag.StringVar(&markdownFile, "markdown", "", "Markdown file to write the report to")
+
+	flag.StringVar(&output, "output", "text", "output format: text, junit or sarif")
+
+	flag.StringVar(&cacheDir, "cache-dir", defaultCacheDir(), "directory where GitHub responses are cached")
+	flag.DurationVar(&cacheMaxAge, "cache-max-age", 7*24*time.Hour, "discard cached responses older than this duration")
+	flag.BoolVar(&noCache, "no-cache", false, "do not use cached GitHub responses")
+
+	flag.StringVar(&backend, "backend", "rest", "API used to read repositories, branches and protection: rest or graphql")
+
+	flag.BoolVar(&useRules, "rules", false, "manage branch protection rules using -branches as wildcard patterns, so that future branches are protected too")
 
-	var branches stringsFlag
-	flag.Var(&branches, "branches", "branches to include (as regexp)")
+	flag.StringVar(&gitlab.pushAccess, "gitlab-push-access", "", "GitLab role allowed to push: no-one, developer, maintainer or admin (default no-one when the policy requires reviews or enforces admins, maintainer otherwise)")
+	flag.StringVar(&gitlab.mergeAccess, "gitlab-merge-access", "maintainer", "GitLab role allowed to merge: no-one, developer, maintainer or admin")
+	flag.BoolVar(&gitlab.allowForcePush, "gitlab-allow-force-push", false, "allow force push on GitLab protected branches")
+	flag.BoolVar(&gitlab.codeOwnerApproval, "gitlab-code-owner-approval", false, "require code owner approval on GitLab protected branches")
+
+	var reposFile string
+	flag.StringVar(&reposFile, "repos-file", "", "file listing repositories fullname to protect, one per line (- to read stdin)")
+	var excludeOrgs stringsFlag
+	flag.Var(&excludeOrgs, "exclude-orgs", "organizations to ignore with -all-orgs (as regexp)")
+	var branches, branchGlobs stringsFlag
+	flag.Var(&branches, "branches", "branches to include (as regexp, or as glob when prefixed with glob:)")
+	flag.Var(&branchGlobs, "branches-glob", "branches to include (as glob, ex: release/*)")
 
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, fmt.Sprintf(banner, currentVersion.VERSION, currentVersion.GITCOMMIT))
+		fmt.Fprint(os.Stderr, usage)
 		flag.PrintDefaults()
 	}
 
-	flag.Parse()
+	command := "protect"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
 
 	if version {
 		fmt.Printf("%s (%s)", currentVersion.VERSION, currentVersion.GITCOMMIT)
 		os.Exit(0)
 	}
 
+	if command == "verify-log" {
+		head, count, err := verifyAuditLog(auditFile, flag.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d records, head %s, no tampering detected\n", auditFile, count, head)
+		os.Exit(0)
+	}
+
 	if ghToken == "" {
-		usageAndExit("GitHub token cannot be empty.", 1)
+		usageAndExit("Token cannot be empty.", 1)
+	}
+
+	switch command {
+	case "protect":
+	case "free":
+		unprotect = true
+	case "expire":
+	case "explain":
+		if flag.NArg() != 2 {
+			usageAndExit("explain needs a repository and a branch: protector explain [flags] owner/repo branch", 1)
+		}
+		protectRepositories, orgs, teams, allOrgs, reposFile = stringsFlag{flag.Arg(0)}, nil, nil, false, ""
+		dryrun = true
+		interval = 0
+	case "report":
+		if htmlFile == "" && markdownFile == "" {
+			usageAndExit("A report needs an -html or -markdown file to be written to.", 1)
+		}
+		unprotect = false
+		dryrun = true
+		interval = 0
+	default:
+		usageAndExit(fmt.Sprintf("Unknown command: %s", command), 1)
+	}
+
+	if output != "text" && output != "junit" && output != "sarif" {
+		usageAndExit(fmt.Sprintf("Unknown output format: %s", output), 1)
+	}
+
+	if backend != "rest" && backend != "graphql" {
+		usageAndExit(fmt.Sprintf("Unknown backend: %s", backend), 1)
+	}
+
+	if providerName != "github" && (backend != "rest" || useRules) {
+		usageAndExit("GraphQL backend and protection rules are only available on GitHub", 1)
+	}
+
+	if leaseDuration != 0 && (leaseDuration < 0 || !unprotect || useRules) {
+		usageAndExit("-for needs a positive duration and frees branches, protection rules can't be freed for a duration", 1)
+	}
+
+	if leaseDuration != 0 && interval > 0 {
+		usageAndExit("-for can't be used with -interval, branches would be freed again on every run", 1)
+	}
+
+	if discoverCommits < 0 || (discoverCommits > 0 && (providerName != "github" || useRules)) {
+		usageAndExit("-discover-contexts needs a positive number of commits and is only available on GitHub without protection rules", 1)
+	}
+
+	if applyContexts && discoverCommits == 0 {
+		usageAndExit("-apply-contexts needs -discover-contexts", 1)
+	}
+
+	if verifyCommits < 0 || (strictContexts && verifyCommits == 0) {
+		usageAndExit("-verify-contexts needs a positive number of commits to use -strict-contexts", 1)
+	}
+
+	if providerName != "github" || useRules {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "verify-contexts" || f.Name == "strict-contexts" {
+				usageAndExit("-verify-contexts and -strict-contexts are only available on GitHub without protection rules", 1)
+			}
+		})
+		verifyCommits = 0
+	}
+
+	if reposFile == "-" && unprotect && !dryrun && !assumeYes {
+		usageAndExit("-repos-file - reads stdin, freeing branches needs -yes as they can't be confirmed", 1)
+	}
+
+	if reposFile != "" {
+		repos, err := readRepositoriesFile(reposFile)
+		if err != nil {
+			usageAndExit(err.Error(), 1)
+		}
+		protectRepositories = append(protectRepositories, repos...)
+	}
+
+	for _, repo := range protectRepositories {
+		if err := checkRepositoryName(repo); err != nil {
+			usageAndExit(err.Error(), 1)
+		}
 	}
 
 	if len(orgs) > 0 && len(protectRepositories) > 0 {
 		usageAndExit("Can't filter repositories by name and organization at the same time", 1)
 	}
 
+	if len(teams) > 0 && (len(orgs) > 0 || len(protectRepositories) > 0) {
+		usageAndExit("Can't filter repositories by team and by name or organization at the same time", 1)
+	}
+
+	if len(teams) > 0 && (providerName != "github" || backend != "rest") {
+		usageAndExit("Teams are only available on GitHub with the REST backend", 1)
+	}
+
+	if allOrgs && (len(orgs) > 0 || len(protectRepositories) > 0 || len(teams) > 0) {
+		usageAndExit("Can't discover organizations and filter repositories at the same time", 1)
+	}
+
+	if allOrgs && (providerName != "github" || backend != "rest") {
+		usageAndExit("Organizations discovery is only available on GitHub with the REST backend", 1)
+	}
+
+	for _, org := range excludeOrgs {
+		re, err := regexp.Compile(org)
+		if err != nil {
+			usageAndExit(fmt.Sprintf("Invalid organization regexp %s: %v", org, err), 1)
+		}
+		excludedOrgs = append(excludedOrgs, re)
+	}
+
+	for _, team := range teams {
+		if parts := strings.SplitN(team, "/", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			usageAndExit(fmt.Sprintf("Team must be given as org/team-slug: %s", team), 1)
+		}
+	}
+
+	if unprotect && len(branches) == 0 && len(branchGlobs) == 0 {
+		usageAndExit("Branches to free must be selected explicitly with -branches or -branches-glob", 1)
+	}
+
+	for _, glob := range branchGlobs {
+		branches = append(branches, globPrefix+glob)
+	}
 	protectBranches = make([]*regexp.Regexp, 0)
 	for _, branch := range branches {
-		protectBranches = append(protectBranches, regexp.MustCompile(branch))
+		re, err := compileBranchPattern(branch)
+		if err != nil {
+			usageAndExit(err.Error(), 1)
+		}
+		protectBranches = append(protectBranches, re)
 	}
 
 	if len(protectBranches) == 0 {
@@ -88,56 +315,290 @@ func main() {
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: ghToken},
 	)
-	tc := oauth2.NewClient(oauth2.NoContext, ts)
-	client := github.NewClient(tc)
+	sum := newSummary()
+	httpClient := &http.Client{Transport: &countingTransport{base: http.DefaultTransport, summary: sum}}
+	var m *metrics
+	if metricsAddr != "" {
+		m = newMetrics()
+		httpClient.Transport = &instrumentedTransport{base: httpClient.Transport, metrics: m}
+		go serveMetrics(m)
+	}
+	if !noCache {
+		httpClient.Transport = &cacheTransport{base: httpClient.Transport, dir: cacheDir, maxAge: cacheMaxAge}
+	}
+	tc := oauth2.NewClient(context.WithValue(oauth2.NoContext, oauth2.HTTPClient, httpClient), ts)
 
-	var ghr repositories
-	if len(protectRepositories) > 0 {
-		ghr = &selectedGitHubRepositories{
-			client:        client,
-			selectedRepos: protectRepositories,
-		}
-	} else if len(orgs) > 0 {
-		ghr = &orgsGitHubRepositories{
-			client: client,
-			orgs:   orgs,
-		}
-	} else {
-		ghr = &allGitHubRepositories{
-			client: client,
-		}
+	var host provider
+	var err error
+	switch providerName {
+	case "github":
+		host, err = newGitHubProvider(tc, apiURL, backend)
+	case "gitlab":
+		host, err = newGitLabProvider(tc, apiURL, gitlab)
+	case "gitea":
+		host, err = newGiteaProvider(tc, apiURL)
+	case "bitbucket":
+		host, err = newBitbucketProvider(tc, apiURL)
+	default:
+		err = fmt.Errorf("Unknown provider: %s", providerName)
+	}
+	if err != nil {
+		usageAndExit(err.Error(), 1)
+	}
+	if m != nil {
+		m.basePath = host.apiURL().Path
 	}
 
-	repos := ghr.fetch()
+	// leases are restored with the REST API, the GraphQL backend only knows repositories it listed
+	service, restService := host.service(), host.service()
+	if b, ok := service.(*graphqlBackend); ok {
+		restService = b.repositoriesService
+	}
+	var audit *auditLog
+	if auditFile != "" && !dryrun {
+		if _, _, err := verifyAuditLog(auditFile, ""); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%v\nnothing has been changed, the audit log must be fixed (or another -audit-log used) first\n", err)
+			os.Exit(1)
+		}
+		audit = &auditLog{path: auditFile, actor: actor(host)}
+		service = newAuditedService(service, audit)
+		restService = newAuditedService(restService, audit)
+	}
 
 	gp := &githubProtection{
-		repositoriesService: client.Repositories,
+		repositoriesService: service,
 		branchPatterns:      protectBranches,
 		successOutput:       os.Stdout,
 		failureOutput:       os.Stderr,
+		listeners:           []listener{sum},
+		leases:              &leaseStore{path: leaseFile},
+		leaseDuration:       leaseDuration,
+		skipLeased:          interval > 0,
+		dryrun:              dryrun,
+		audit:               audit,
+		repoPolicyPath:      repoPolicyPath,
+	}
+	if files, ok := host.(interface {
+		file(*github.Repository, string, string) ([]byte, error)
+	}); ok {
+		gp.readFile = files.file
+	}
+	if members, ok := host.(interface {
+		repoTeams(*github.Repository) ([]string, error)
+	}); ok {
+		gp.repoTeams = members.repoTeams
+	}
+	if attributes, ok := host.(interface {
+		archived(*github.Repository) (bool, error)
+	}); ok {
+		gp.repoArchived = attributes.archived
+	}
+	if checker, ok := host.(interface {
+		checkSettings(*github.Repository, string) ([]finding, error)
+	}); ok {
+		gp.hostSettings = checker.checkSettings
+	}
+	if owners, ok := host.(codeOwnersHost); ok {
+		gp.codeOwners = owners
+	}
+	if statuses, ok := host.(interface {
+		commitContexts(*github.Repository, string, int) ([][]string, error)
+	}); ok {
+		gp.commitContexts, gp.discoverCommits, gp.applyContexts = statuses.commitContexts, discoverCommits, applyContexts
+		gp.verifyCommits, gp.strictContexts = verifyCommits, strictContexts
+	}
+	if policyFile != "" {
+		policies, err := readPolicy(policyFile)
+		if err != nil {
+			usageAndExit(err.Error(), 1)
+		}
+		if providerName != "github" && (len(policies.rules) > 0 || len(policies.topics) > 0) {
+			usageAndExit("Policy rules and topics are only available on GitHub, other providers don't give the repository attributes they match", 1)
+		}
+		global, err := policies.global.policy()
+		if err != nil {
+			usageAndExit(err.Error(), 1)
+		}
+		gp.policies, gp.policy = policies, global
+	}
+	if m != nil {
+		gp.listeners = append(gp.listeners, m)
+	}
+	collector := new(reportCollector)
+	if command == "report" || output != "text" {
+		gp.successOutput = ioutil.Discard
+		gp.listeners = append(gp.listeners, collector)
 	}
 
+	if checker, ok := host.(interface {
+		unsupported(*policy) []string
+	}); ok {
+		for _, warning := range checker.unsupported(gp.policy) {
+			fmt.Fprintf(os.Stderr, "warning: %s provider ignores %s\n", providerName, warning)
+		}
+	}
+	if checker, ok := host.(interface {
+		supported(*policy) *policy
+	}); ok {
+		gp.supported = checker.supported
+	}
+
+	newProtection := func(gp *githubProtection) protection {
+		if useRules {
+			return newRuleProtection(gp, host.(*githubProvider).graphql)
+		}
+		return gp
+	}
+	p := newProtection(gp)
+
+	expiring := *gp
+	expiring.repositoriesService = restService
+	if command == "explain" {
+		explained := false
+		for repo := range host.repositories().fetch(gp.report) {
+			if err := gp.explain(os.Stdout, repo, flag.Arg(1)); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			explained = true
+		}
+		if !explained {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if command == "expire" {
+		if err := gp.leases.expire(&expiring, time.Now()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sum.print(os.Stderr)
+		os.Exit(0)
+	}
+
+	for {
+		start := time.Now()
+		collector.reset()
+		sum.reset()
+		if m != nil {
+			m.startCycle()
+		}
+
+		if interval > 0 {
+			if err := gp.leases.expire(&expiring, start); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		gp.planned = nil
+		if !dryrun && (maxChanges > 0 || (unprotect && !assumeYes)) {
+			changes, done := plan(host.repositories(), gp, newProtection)
+			if maxChanges > 0 && len(changes) > maxChanges {
+				fmt.Fprintf(os.Stderr, "aborted: %s would be changed, more than -max-changes %d\n", describe(changes), maxChanges)
+				os.Exit(1)
+			}
+			if unprotect && !assumeYes && len(changes) > confirmAbove && !confirm(os.Stdin, os.Stderr, "Freeing", changes) {
+				fmt.Fprintln(os.Stderr, "aborted: nothing has been changed")
+				os.Exit(1)
+			}
+			for _, r := range done {
+				p.report(r)
+			}
+			gp.planned = plannedBranches(changes)
+			run(&plannedRepositories{changes: changes}, p)
+		} else {
+			run(host.repositories(), p)
+		}
+
+		if m != nil {
+			m.endCycle(time.Since(start))
+		}
+		sum.print(os.Stderr)
+		if err := writeOutput(os.Stdout, collector); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if interval <= 0 {
+			break
+		}
+		time.Sleep(interval)
+	}
+
+	if command == "report" {
+		report := collector.build()
+		if htmlFile != "" {
+			if err := writeReport(htmlFile, htmlReport, report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		if markdownFile != "" {
+			if err := writeReport(markdownFile, markdownReport, report); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if sum.unresolved() > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// actor identifies the owner of the token in the audit log.
+func actor(host provider) string {
+	if identified, ok := host.(interface {
+		identity() (string, error)
+	}); ok {
+		login, err := identified.identity()
+		if err == nil {
+			return login
+		}
+		fmt.Fprintf(os.Stderr, "warning: token owner can't be identified for the audit log: %v\n", err)
+	}
+	return "unknown"
+}
+
+func run(ghr repositories, p protection) {
 	var wg sync.WaitGroup
-	for repo := range repos {
+	for repo := range ghr.fetch(p.report) {
 		wg.Add(1)
 		go func(repository *github.Repository) {
 			defer wg.Done()
 
 			if unprotect {
-				gp.free(repository)
+				p.free(repository)
 			} else {
-				gp.protect(repository)
+				p.protect(repository)
 			}
 		}(repo)
 	}
 	wg.Wait()
+}
 
-	os.Exit(0)
+func writeOutput(w io.Writer, collector *reportCollector) error {
+	switch output {
+	case "junit":
+		return writeJUnit(w, collector.sorted())
+	case "sarif":
+		return writeSARIF(w, collector.sorted(), currentVersion.VERSION)
+	}
+	return nil
+}
+
+func serveMetrics(m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 func usageAndExit(message string, exitCode int) {
 	if message != "" {
-		fmt.Fprintf(os.Stderr, message)
+		fmt.Fprint(os.Stderr, message)
 		fmt.Fprint(os.Stderr, "\n\n")
 	}
 	flag.Usage()
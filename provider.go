@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// provider gives access to the repositories of a git host and to their branch protection.
+// Repositories and branches of every host are described with the GitHub types.
+type provider interface {
+	repositories() repositories
+	service() repositoriesService
+	apiURL() *url.URL
+}
+
+type githubProvider struct {
+	client        *github.Client
+	graphql       *graphqlClient
+	orgs          []string
+	selectedRepos []string
+	teams         []string
+	allOrgs       bool
+	excludedOrgs  []*regexp.Regexp
+	enterprise    bool
+	backend       *graphqlBackend
+}
+
+func newGitHubProvider(httpClient *http.Client, baseURL string, backend string) (*githubProvider, error) {
+	client := github.NewClient(httpClient)
+	if baseURL != "" {
+		u, err := parseBaseURL(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		client.BaseURL = u
+	}
+
+	gp := &githubProvider{
+		client:        client,
+		graphql:       &graphqlClient{httpClient: httpClient, endpoint: graphqlEndpoint(client.BaseURL)},
+		orgs:          orgs,
+		selectedRepos: protectRepositories,
+		teams:         teams,
+		allOrgs:       allOrgs,
+		excludedOrgs:  excludedOrgs,
+		enterprise:    baseURL != "",
+	}
+	if backend == "graphql" {
+		gp.backend = &graphqlBackend{
+			repositoriesService: &githubRepositoriesService{client.Repositories, client},
+			client:              gp.graphql,
+			orgs:                gp.orgs,
+			selectedRepos:       gp.selectedRepos,
+		}
+	}
+	return gp, nil
+}
+
+func (gp *githubProvider) repositories() repositories {
+	switch {
+	case gp.backend != nil:
+		return gp.backend
+	case len(gp.selectedRepos) > 0:
+		return &selectedGitHubRepositories{
+			client:        gp.client,
+			selectedRepos: gp.selectedRepos,
+		}
+	case gp.allOrgs:
+		return &adminOrgsGitHubRepositories{
+			client:     gp.client,
+			enterprise: gp.enterprise,
+			exclude:    gp.excludedOrgs,
+		}
+	case len(gp.teams) > 0:
+		return &teamsGitHubRepositories{
+			client: gp.client,
+			teams:  gp.teams,
+		}
+	case len(gp.orgs) > 0:
+		return &orgsGitHubRepositories{
+			client: gp.client,
+			orgs:   gp.orgs,
+		}
+	}
+	return &allGitHubRepositories{
+		client: gp.client,
+	}
+}
+
+func (gp *githubProvider) service() repositoriesService {
+	if gp.backend != nil {
+		return gp.backend
+	}
+	return &githubRepositoriesService{gp.client.Repositories, gp.client}
+}
+
+func (gp *githubProvider) apiURL() *url.URL {
+	return gp.client.BaseURL
+}
+
+func (gp *githubProvider) identity() (string, error) {
+	user, _, err := gp.client.Users.Get(context.TODO(), "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
+
+// file reads a file from a branch of a repository, it returns nil when the file doesn't exist.
+func (gp *githubProvider) file(repo *github.Repository, ref, path string) ([]byte, error) {
+	opt := &github.RepositoryContentGetOptions{Ref: ref}
+	content, _, resp, err := gp.client.Repositories.GetContents(context.TODO(), *repo.Owner.Login, *repo.Name, path, opt)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+	decoded, err := content.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(decoded), nil
+}
+
+// teams lists the teams having access to a repository, as org/team-slug.
+func (gp *githubProvider) repoTeams(repo *github.Repository) ([]string, error) {
+	teams := make([]string, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := gp.client.Repositories.ListTeams(context.TODO(), *repo.Owner.Login, *repo.Name, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, team := range page {
+			teams = append(teams, *repo.Owner.Login+"/"+team.GetSlug())
+		}
+		if resp.NextPage == 0 {
+			return teams, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// archived tells if a repository is archived, the vendored client doesn't decode this attribute.
+func (gp *githubProvider) archived(repo *github.Repository) (bool, error) {
+	req, err := gp.client.NewRequest("GET", fmt.Sprintf("repos/%s/%s", *repo.Owner.Login, *repo.Name), nil)
+	if err != nil {
+		return false, err
+	}
+	var attributes struct {
+		Archived bool `json:"archived"`
+	}
+	if _, err := gp.client.Do(context.TODO(), req, &attributes); err != nil {
+		return false, err
+	}
+	return attributes.Archived, nil
+}
+
+// commitContexts lists the contexts of the combined status and the names of the check runs of each of the last
+// commits of a branch.
+func (gp *githubProvider) commitContexts(repo *github.Repository, branch string, commits int) ([][]string, error) {
+	opt := &github.CommitsListOptions{SHA: branch, ListOptions: github.ListOptions{PerPage: commits}}
+	list, _, err := gp.client.Repositories.ListCommits(context.TODO(), *repo.Owner.Login, *repo.Name, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]string, 0, len(list))
+	for _, commit := range list {
+		combined, _, err := gp.client.Repositories.GetCombinedStatus(context.TODO(), *repo.Owner.Login, *repo.Name, commit.GetSHA(), &github.ListOptions{PerPage: 100})
+		if err != nil {
+			return nil, err
+		}
+		contexts := make([]string, 0, len(combined.Statuses))
+		for _, status := range combined.Statuses {
+			contexts = append(contexts, status.GetContext())
+		}
+		runs, err := gp.checkRuns(repo, commit.GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, append(contexts, missing(runs, contexts)...))
+	}
+	return result, nil
+}
+
+const mediaTypeChecksPreview = "application/vnd.github.antiope-preview+json"
+
+// checkRuns lists the names of the check runs of a commit, GitHub Actions report them instead of statuses.
+// The vendored client doesn't know the checks API.
+func (gp *githubProvider) checkRuns(repo *github.Repository, sha string) ([]string, error) {
+	req, err := gp.client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/commits/%s/check-runs?per_page=100", *repo.Owner.Login, *repo.Name, sha), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaTypeChecksPreview)
+	var checks struct {
+		CheckRuns []struct {
+			Name string `json:"name"`
+		} `json:"check_runs"`
+	}
+	if _, err := gp.client.Do(context.TODO(), req, &checks); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(checks.CheckRuns))
+	for _, run := range checks.CheckRuns {
+		names = append(names, run.Name)
+	}
+	return names, nil
+}
+
+// parseBaseURL parses an API URL, adding the trailing slash needed to resolve relative paths.
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return url.Parse(baseURL)
+}
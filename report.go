@@ -0,0 +1,210 @@
+package main
+
+import (
+	htmlTemplate "html/template"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// reportCollector keeps every result of a run to render a compliance report once it is over.
+type reportCollector struct {
+	mu      sync.Mutex
+	results []*result
+}
+
+func (rc *reportCollector) notify(r *result) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.results = append(rc.results, r)
+}
+
+func (rc *reportCollector) reset() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.results = nil
+}
+
+type complianceReport struct {
+	Generated   string
+	Orgs        []*orgCompliance
+	Branches    []*branchCompliance
+	Uninspected []string
+	Failures    []string
+}
+
+type orgCompliance struct {
+	Name         string
+	Repositories int
+	Compliant    int
+}
+
+func (oc *orgCompliance) Percentage() int {
+	if oc.Repositories == 0 {
+		return 100
+	}
+	return oc.Compliant * 100 / oc.Repositories
+}
+
+type branchCompliance struct {
+	Repository string
+	Branch     string
+	Protected  bool
+	Compliant  bool
+	Gaps       []string
+}
+
+func (rc *reportCollector) build() *complianceReport {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	report := &complianceReport{Generated: time.Now().Format(time.RFC1123)}
+	orgs := make(map[string]*orgCompliance)
+	repos := make(map[string]bool)
+	owners := make(map[string]string)
+	for _, r := range rc.results {
+		name := *r.repo.FullName
+		switch {
+		case r.status == statusNoAdmin:
+			report.Uninspected = append(report.Uninspected, name)
+			continue
+		case r.status == statusUnresolved:
+			report.Failures = append(report.Failures, r.message)
+			continue
+		case r.branch == "":
+			report.Failures = append(report.Failures, r.message)
+		default:
+			bc := &branchCompliance{
+				Repository: name,
+				Branch:     r.branch,
+				Protected:  r.protected(),
+				Compliant:  r.status.compliant(),
+			}
+			for _, f := range r.findings {
+				bc.Gaps = append(bc.Gaps, f.message)
+			}
+			if r.status == statusFailed {
+				bc.Gaps = append(bc.Gaps, r.message)
+			}
+			report.Branches = append(report.Branches, bc)
+		}
+
+		if _, ok := orgs[r.org()]; !ok {
+			orgs[r.org()] = &orgCompliance{Name: r.org()}
+		}
+		if compliant, seen := repos[name]; !seen || compliant {
+			repos[name] = r.status.compliant()
+		}
+		owners[name] = r.org()
+	}
+
+	for name, compliant := range repos {
+		org := orgs[owners[name]]
+		org.Repositories++
+		if compliant {
+			org.Compliant++
+		}
+	}
+	for _, org := range orgs {
+		report.Orgs = append(report.Orgs, org)
+	}
+
+	sort.Slice(report.Orgs, func(i, j int) bool { return report.Orgs[i].Name < report.Orgs[j].Name })
+	sort.Slice(report.Branches, func(i, j int) bool {
+		if report.Branches[i].Repository == report.Branches[j].Repository {
+			return report.Branches[i].Branch < report.Branches[j].Branch
+		}
+		return report.Branches[i].Repository < report.Branches[j].Repository
+	})
+	sort.Strings(report.Uninspected)
+	sort.Strings(report.Failures)
+	return report
+}
+
+type executable interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+func writeReport(path string, tmpl executable, report *complianceReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return tmpl.Execute(file, report)
+}
+
+var markdownReport = template.Must(template.New("markdown").Parse(`# Branch protection report
+
+Generated on {{.Generated}}.
+
+## Compliance
+
+| Organization | Repositories | Compliant | Compliance |
+|---|---|---|---|
+{{range .Orgs}}| {{.Name}} | {{.Repositories}} | {{.Compliant}} | {{.Percentage}}% |
+{{end}}
+## Branches
+
+| Repository | Branch | Protected | Gaps |
+|---|---|---|---|
+{{range .Branches}}| {{.Repository}} | {{.Branch}} | {{if .Protected}}yes{{else}}no{{end}} | {{range $i, $gap := .Gaps}}{{if $i}}<br>{{end}}{{$gap}}{{end}} |
+{{end}}{{if .Uninspected}}
+## Repositories not inspected
+
+Admin rights are needed to read branch protection of these repositories:
+
+{{range .Uninspected}}- {{.}}
+{{end}}{{end}}{{if .Failures}}
+## Failures
+
+{{range .Failures}}- {{.}}
+{{end}}{{end}}`))
+
+var htmlReport = htmlTemplate.Must(htmlTemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Branch protection report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #24292e; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #d1d5da; padding: 6px 13px; text-align: left; vertical-align: top; }
+th { background: #f6f8fa; }
+.ok { color: #28a745; }
+.ko { color: #cb2431; }
+ul.gaps { margin: 0; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<h1>Branch protection report</h1>
+<p>Generated on {{.Generated}}.</p>
+
+<h2>Compliance</h2>
+<table>
+<tr><th>Organization</th><th>Repositories</th><th>Compliant</th><th>Compliance</th></tr>
+{{range .Orgs}}<tr><td>{{.Name}}</td><td>{{.Repositories}}</td><td>{{.Compliant}}</td><td class="{{if eq .Percentage 100}}ok{{else}}ko{{end}}">{{.Percentage}}%</td></tr>
+{{end}}</table>
+
+<h2>Branches</h2>
+<table>
+<tr><th>Repository</th><th>Branch</th><th>Protected</th><th>Gaps</th></tr>
+{{range .Branches}}<tr><td>{{.Repository}}</td><td>{{.Branch}}</td><td class="{{if .Compliant}}ok{{else}}ko{{end}}">{{if .Protected}}yes{{else}}no{{end}}</td><td>{{if .Gaps}}<ul class="gaps">{{range .Gaps}}<li>{{.}}</li>{{end}}</ul>{{end}}</td></tr>
+{{end}}</table>
+{{if .Uninspected}}
+<h2>Repositories not inspected</h2>
+<p>Admin rights are needed to read branch protection of these repositories:</p>
+<ul>
+{{range .Uninspected}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{if .Failures}}
+<h2>Failures</h2>
+<ul>
+{{range .Failures}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
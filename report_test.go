@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/go-github/github"
+	"strings"
+	"testing"
+)
+
+func TestReportComputesComplianceByOrganization(t *testing.T) {
+	// Given
+	login := "jcgay"
+	collector := new(reportCollector)
+	for _, name := range []string{"jcgay/maven-color", "jcgay/protector", "jcgay/secret"} {
+		repoName := name
+		repo := &github.Repository{FullName: &repoName, Owner: &github.User{Login: &login}}
+		switch name {
+		case "jcgay/maven-color":
+			collector.notify(newResult(repo, "master", statusAlreadyProtected, "is already protected"))
+		case "jcgay/protector":
+			collector.notify(newResult(repo, "master", statusToProtect, "will be set to protected", finding{ruleProtected, "branch is not protected"}))
+		default:
+			collector.notify(&result{repo: repo, status: statusNoAdmin})
+		}
+	}
+
+	// When
+	out := new(bytes.Buffer)
+	if err := markdownReport.Execute(out, collector.build()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	for _, expected := range []string{
+		"| jcgay | 2 | 1 | 50% |",
+		"| jcgay/protector | master | no | branch is not protected |",
+		"- jcgay/secret",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Report should contain [%s], got: [%s]", expected, out.String())
+		}
+	}
+}
+
+func TestReportComputesComplianceOfSubgroups(t *testing.T) {
+	// Given
+	owner, name := "group/sub", "group/sub/api"
+	collector := new(reportCollector)
+	collector.notify(newResult(&github.Repository{FullName: &name, Owner: &github.User{Login: &owner}}, "master", statusAlreadyProtected, "is already protected"))
+	collector.notify(newUnresolvedResult("group/sub/missing", errors.New("not found")))
+
+	// When
+	out := new(bytes.Buffer)
+	if err := markdownReport.Execute(out, collector.build()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	if !strings.Contains(out.String(), "| group/sub | 1 | 1 | 100% |") {
+		t.Errorf("Report should count the repository of the subgroup, got: [%s]", out.String())
+	}
+	if r := newUnresolvedResult("group/sub/missing", errors.New("not found")); r.org() != "group/sub" {
+		t.Errorf("Owner of a nested project should be its group, got %s", r.org())
+	}
+}
@@ -1,28 +1,34 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"fmt"
 	"github.com/google/go-github/github"
-	"log"
+	"io"
+	"os"
+	"regexp"
 	"strings"
 	"sync"
 )
 
+// repositories sends the repositories to process, the ones (or the organizations) that can't be fetched
+// are reported to unresolved.
 type repositories interface {
-	fetch() chan *github.Repository
+	fetch(unresolved func(*result)) chan *github.Repository
 }
 
 type allGitHubRepositories struct {
 	client *github.Client
 }
 
-func (aghr *allGitHubRepositories) fetch() chan *github.Repository {
+func (aghr *allGitHubRepositories) fetch(unresolved func(*result)) chan *github.Repository {
 	result := make(chan *github.Repository, 20)
-	aghr.list(1, result)
+	aghr.list(1, result, unresolved)
 	return result
 }
 
-func (aghr *allGitHubRepositories) list(startPage int, result chan *github.Repository) {
+func (aghr *allGitHubRepositories) list(startPage int, result chan *github.Repository, unresolved func(*result)) {
 	opt := &github.RepositoryListOptions{
 		ListOptions: github.ListOptions{
 			Page:    startPage,
@@ -32,7 +38,7 @@ func (aghr *allGitHubRepositories) list(startPage int, result chan *github.Repos
 
 	repos, resp, err := aghr.client.Repositories.List(context.TODO(), "", opt)
 	if err != nil {
-		log.Println(err)
+		unresolved(newUnresolvedResult("user", err))
 		close(result)
 		return
 	}
@@ -47,16 +53,67 @@ func (aghr *allGitHubRepositories) list(startPage int, result chan *github.Repos
 	}
 
 	go func() {
-		aghr.list(resp.NextPage, result)
+		aghr.list(resp.NextPage, result, unresolved)
 	}()
 }
 
+func readRepositoriesFile(path string) ([]string, error) {
+	if path == "-" {
+		return readRepositories(os.Stdin, "stdin")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readRepositories(f, path)
+}
+
+// readRepositories reads one owner/repo name per line, blank lines and text following # are ignored.
+func readRepositories(r io.Reader, name string) ([]string, error) {
+	repos := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		repo := scanner.Text()
+		if i := strings.Index(repo, "#"); i >= 0 {
+			repo = repo[:i]
+		}
+		repo = strings.TrimSpace(repo)
+		if repo == "" {
+			continue
+		}
+		if err := checkRepositoryName(repo); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		repos = append(repos, repo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Can't read repositories from %s: %v", name, err)
+	}
+	return repos, nil
+}
+
+// checkRepositoryName accepts owner/repo names, GitLab projects can also be nested in subgroups (group/subgroup/project).
+func checkRepositoryName(repo string) error {
+	metas := strings.Split(repo, "/")
+	valid := len(metas) == 2 || (providerName == "gitlab" && len(metas) > 2)
+	for _, meta := range metas {
+		if meta == "" {
+			valid = false
+		}
+	}
+	if !valid || strings.ContainsAny(repo, " \t") {
+		return fmt.Errorf("%q is not a repository fullname (owner/repo)", repo)
+	}
+	return nil
+}
+
 type selectedGitHubRepositories struct {
 	client        *github.Client
 	selectedRepos []string
 }
 
-func (sghr *selectedGitHubRepositories) fetch() chan *github.Repository {
+func (sghr *selectedGitHubRepositories) fetch(unresolved func(*result)) chan *github.Repository {
 	result := make(chan *github.Repository)
 	var wg sync.WaitGroup
 	for _, repoFullName := range sghr.selectedRepos {
@@ -64,9 +121,12 @@ func (sghr *selectedGitHubRepositories) fetch() chan *github.Repository {
 		go func(name string) {
 			defer wg.Done()
 			metas := strings.SplitN(name, "/", 2)
-			if repo, _, err := sghr.client.Repositories.Get(context.TODO(), metas[0], metas[1]); err == nil {
-				result <- repo
+			repo, _, err := sghr.client.Repositories.Get(context.TODO(), metas[0], metas[1])
+			if err != nil {
+				unresolved(newUnresolvedResult(name, err))
+				return
 			}
+			result <- repo
 		}(repoFullName)
 	}
 
@@ -83,14 +143,16 @@ type orgsGitHubRepositories struct {
 	orgs   []string
 }
 
-func (aghr *orgsGitHubRepositories) fetch() chan *github.Repository {
+func (aghr *orgsGitHubRepositories) fetch(unresolved func(*result)) chan *github.Repository {
 	result := make(chan *github.Repository, 20)
 	var wg sync.WaitGroup
-	for _, orga := range orgs {
+	for _, orga := range aghr.orgs {
 		wg.Add(1)
 		go func(orga string) {
 			defer wg.Done()
-			aghr.listByOrg(1, orga, result)
+			if err := aghr.listByOrg(1, orga, result); err != nil {
+				unresolved(newUnresolvedResult(orga, err))
+			}
 		}(orga)
 	}
 
@@ -102,7 +164,7 @@ func (aghr *orgsGitHubRepositories) fetch() chan *github.Repository {
 	return result
 }
 
-func (aghr *orgsGitHubRepositories) listByOrg(startPage int, orga string, result chan *github.Repository) {
+func (aghr *orgsGitHubRepositories) listByOrg(startPage int, orga string, result chan *github.Repository) error {
 	opt := &github.RepositoryListByOrgOptions{
 		ListOptions: github.ListOptions{
 			Page:    startPage,
@@ -112,8 +174,7 @@ func (aghr *orgsGitHubRepositories) listByOrg(startPage int, orga string, result
 
 	repos, resp, err := aghr.client.Repositories.ListByOrg(context.TODO(), orga, opt)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
 	for _, repo := range repos {
@@ -121,8 +182,176 @@ func (aghr *orgsGitHubRepositories) listByOrg(startPage int, orga string, result
 	}
 
 	if startPage == resp.LastPage || resp.NextPage == 0 {
-		return
+		return nil
 	}
 
-	aghr.listByOrg(resp.NextPage, orga, result)
+	return aghr.listByOrg(resp.NextPage, orga, result)
+}
+
+// teamsGitHubRepositories lists the repositories of teams, given as org/team-slug.
+// A repository shared by several teams is only sent once.
+type teamsGitHubRepositories struct {
+	client *github.Client
+	teams  []string
+}
+
+func (tghr *teamsGitHubRepositories) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, 20)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	for _, team := range tghr.teams {
+		wg.Add(1)
+		go func(team string) {
+			defer wg.Done()
+			metas := strings.SplitN(team, "/", 2)
+			id, err := tghr.teamID(metas[0], metas[1])
+			if err != nil {
+				unresolved(newUnresolvedResult(team, err))
+				return
+			}
+			err = tghr.listByTeam(id, func(repo *github.Repository) {
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[repo.GetFullName()] {
+					return
+				}
+				seen[repo.GetFullName()] = true
+				result <- repo
+			})
+			if err != nil {
+				unresolved(newUnresolvedResult(team, err))
+			}
+		}(team)
+	}
+
+	go func() {
+		wg.Wait()
+		close(result)
+	}()
+
+	return result
+}
+
+func (tghr *teamsGitHubRepositories) teamID(org, slug string) (int, error) {
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		teams, resp, err := tghr.client.Organizations.ListTeams(context.TODO(), org, opt)
+		if err != nil {
+			return 0, err
+		}
+		for _, team := range teams {
+			if team.GetSlug() == slug {
+				return team.GetID(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, fmt.Errorf("team %s not found in organization %s", slug, org)
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (tghr *teamsGitHubRepositories) listByTeam(id int, send func(*github.Repository)) error {
+	opt := &github.ListOptions{PerPage: 20}
+	for {
+		repos, resp, err := tghr.client.Organizations.ListTeamRepos(context.TODO(), id, opt)
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			send(repo)
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// adminOrgsGitHubRepositories lists the repositories of every organization the token can administer, organizations
+// are discovered at each fetch so that new ones are protected too. On GitHub Enterprise, site administrators
+// administer all organizations.
+type adminOrgsGitHubRepositories struct {
+	client     *github.Client
+	enterprise bool
+	exclude    []*regexp.Regexp
+}
+
+func (aoghr *adminOrgsGitHubRepositories) fetch(unresolved func(*result)) chan *github.Repository {
+	orgs, err := aoghr.adminOrgs()
+	if err != nil {
+		unresolved(newUnresolvedResult("organizations", err))
+		result := make(chan *github.Repository)
+		close(result)
+		return result
+	}
+
+	return (&orgsGitHubRepositories{client: aoghr.client, orgs: orgs}).fetch(unresolved)
+}
+
+func (aoghr *adminOrgsGitHubRepositories) adminOrgs() ([]string, error) {
+	if aoghr.enterprise {
+		user, _, err := aoghr.client.Users.Get(context.TODO(), "")
+		if err != nil {
+			return nil, err
+		}
+		if user.GetSiteAdmin() {
+			return aoghr.allOrgs()
+		}
+	}
+
+	orgs := make([]string, 0)
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := aoghr.client.Organizations.List(context.TODO(), "", opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range page {
+			if aoghr.excluded(org.GetLogin()) {
+				continue
+			}
+			membership, _, err := aoghr.client.Organizations.GetOrgMembership(context.TODO(), "", org.GetLogin())
+			if err != nil {
+				return nil, err
+			}
+			if membership.GetRole() == "admin" {
+				orgs = append(orgs, org.GetLogin())
+			}
+		}
+		if resp.NextPage == 0 {
+			return orgs, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+func (aoghr *adminOrgsGitHubRepositories) allOrgs() ([]string, error) {
+	orgs := make([]string, 0)
+	opt := &github.OrganizationsListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, _, err := aoghr.client.Organizations.ListAll(context.TODO(), opt)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return orgs, nil
+		}
+		for _, org := range page {
+			if !aoghr.excluded(org.GetLogin()) {
+				orgs = append(orgs, org.GetLogin())
+			}
+		}
+		opt.Since = page[len(page)-1].GetID()
+	}
+}
+
+func (aoghr *adminOrgsGitHubRepositories) excluded(org string) bool {
+	for _, re := range aoghr.exclude {
+		if re.MatchString(org) {
+			return true
+		}
+	}
+	return false
 }
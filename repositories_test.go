@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTeamsRepositoriesAreListedOnce(t *testing.T) {
+	// Given
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/my-org/teams", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 1, "slug": "backend"}, {"id": 2, "slug": "frontend"}]`)
+	})
+	mux.HandleFunc("/teams/1/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"full_name": "my-org/api"}, {"full_name": "my-org/shared"}]`)
+	})
+	mux.HandleFunc("/teams/2/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"full_name": "my-org/web"}, {"full_name": "my-org/shared"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	tghr := &teamsGitHubRepositories{client: client, teams: []string{"my-org/backend", "my-org/frontend"}}
+
+	// When
+	repos := make([]string, 0)
+	for repo := range tghr.fetch(func(*result) {}) {
+		repos = append(repos, *repo.FullName)
+	}
+
+	// Then
+	sort.Strings(repos)
+	if fmt.Sprint(repos) != "[my-org/api my-org/shared my-org/web]" {
+		t.Errorf("Expected each team repository once, got %v", repos)
+	}
+}
+
+func TestAllOrgsKeepsAdministeredOrganizations(t *testing.T) {
+	// Given
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/orgs", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"login": "admin-org"}, {"login": "member-org"}, {"login": "sandbox-org"}]`)
+	})
+	mux.HandleFunc("/user/memberships/orgs/admin-org", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"role": "admin"}`)
+	})
+	mux.HandleFunc("/user/memberships/orgs/member-org", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"role": "member"}`)
+	})
+	mux.HandleFunc("/user/memberships/orgs/sandbox-org", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Excluded organization should not be inspected")
+	})
+	mux.HandleFunc("/orgs/admin-org/repos", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"full_name": "admin-org/api"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	aoghr := &adminOrgsGitHubRepositories{client: client, exclude: []*regexp.Regexp{regexp.MustCompile("^sandbox-")}}
+
+	// When
+	repos := make([]string, 0)
+	for repo := range aoghr.fetch(func(*result) {}) {
+		repos = append(repos, *repo.FullName)
+	}
+
+	// Then
+	if fmt.Sprint(repos) != "[admin-org/api]" {
+		t.Errorf("Expected repositories of administered organizations only, got %v", repos)
+	}
+}
+
+func TestReadRepositoriesIgnoresCommentsAndBlankLines(t *testing.T) {
+	// Given
+	content := `# platform repositories
+jcgay/maven-color
+
+jcgay/protector # this one too
+`
+
+	// When
+	repos, err := readRepositories(strings.NewReader(content), "repos.txt")
+
+	// Then
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(repos) != "[jcgay/maven-color jcgay/protector]" {
+		t.Errorf("Unexpected repositories %v", repos)
+	}
+}
+
+func TestReadRepositoriesReportsMalformedLine(t *testing.T) {
+	// Given
+	content := "jcgay/maven-color\nprotector\n"
+
+	// When
+	_, err := readRepositories(strings.NewReader(content), "repos.txt")
+
+	// Then
+	if err == nil || !strings.HasPrefix(err.Error(), "repos.txt:2:") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+}
+
+func TestCheckRepositoryName(t *testing.T) {
+	defer func(previous string) { providerName = previous }(providerName)
+	for _, test := range []struct {
+		provider string
+		repo     string
+		valid    bool
+	}{
+		{"github", "jcgay/protector", true},
+		{"github", "jcgay/protector/extra", false},
+		{"github", "jcgay/", false},
+		{"github", "jcgay//protector", false},
+		{"gitlab", "platform/backend/api", true},
+		{"gitlab", "platform//api", false},
+	} {
+		// Given
+		providerName = test.provider
+
+		// When
+		err := checkRepositoryName(test.repo)
+
+		// Then
+		if (err == nil) != test.valid {
+			t.Errorf("%s on %s should be valid: %t, got: %v", test.repo, test.provider, test.valid, err)
+		}
+	}
+}
+
+func TestFetchReportsUnresolvedRepositoriesAndOrganizations(t *testing.T) {
+	// Given
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/jcgay/maven-color", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"full_name": "jcgay/maven-color"}`)
+	})
+	mux.HandleFunc("/repos/jcgay/typo", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+	mux.HandleFunc("/orgs/my-org/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			http.Error(w, `{"message": "Server Error"}`, http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next"`)
+		fmt.Fprint(w, `[{"full_name": "my-org/api"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	for _, repos := range []repositories{
+		&selectedGitHubRepositories{client: client, selectedRepos: []string{"jcgay/maven-color", "jcgay/typo"}},
+		&orgsGitHubRepositories{client: client, orgs: []string{"my-org"}},
+	} {
+		// When
+		var mu sync.Mutex
+		failures := make([]*result, 0)
+		fetched := 0
+		for range repos.fetch(func(r *result) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, r)
+		}) {
+			fetched++
+		}
+
+		// Then
+		if fetched != 1 || len(failures) != 1 {
+			t.Fatalf("Expected one repository and one failure, got %d and %d", fetched, len(failures))
+		}
+		if failures[0].status != statusUnresolved || failures[0].httpStatus == 0 {
+			t.Errorf("Expected an unresolved result with its HTTP status, got %+v", failures[0])
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// restClient calls JSON APIs of the git hosts not covered by go-github.
+type restClient struct {
+	httpClient *http.Client
+	baseURL    *url.URL
+	pageParam  string
+	pageSize   int
+}
+
+type restError struct {
+	resp *http.Response
+	body string
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("%s %s: %s %s", e.resp.Request.Method, e.resp.Request.URL, e.resp.Status, e.body)
+}
+
+// do sends a request to the API and decodes the JSON response in result when not nil.
+func (gc *restClient) do(ctx context.Context, method, path string, body interface{}, result interface{}) (*http.Response, error) {
+	u, err := gc.baseURL.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		content = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, u.String(), content)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := gc.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message := new(bytes.Buffer)
+		io.Copy(message, io.LimitReader(resp.Body, 1024))
+		return resp, &restError{resp: resp, body: message.String()}
+	}
+	if result != nil {
+		return resp, json.NewDecoder(resp.Body).Decode(result)
+	}
+	return resp, nil
+}
+
+// each calls fn with every page of a paginated collection.
+func (gc *restClient) each(ctx context.Context, path string, newPage func() interface{}, fn func(page interface{})) error {
+	next := "1"
+	for next != "" {
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		page := newPage()
+		resp, err := gc.do(ctx, "GET", fmt.Sprintf("%s%s%s=%d&page=%s", path, separator, gc.pageParam, gc.pageSize, next), nil, page)
+		if err != nil {
+			return err
+		}
+		fn(page)
+		next = nextPage(resp)
+	}
+	return nil
+}
+
+var linkNextPage = regexp.MustCompile(`<[^>]*[?&]page=(\d+)[^>]*>;\s*rel="next"`)
+
+// nextPage reads the next page number from the X-Next-Page header (GitLab) or from the Link header (Gitea, Bitbucket...).
+func nextPage(resp *http.Response) string {
+	if next := resp.Header.Get("X-Next-Page"); next != "" {
+		return next
+	}
+	if match := linkNextPage.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// restBranch is the branch description shared by GitLab and Gitea.
+type restBranch struct {
+	Name      string `json:"name"`
+	Protected bool   `json:"protected"`
+}
+
+func (b *restBranch) toGitHub() *github.Branch {
+	name, protected := b.Name, b.Protected
+	return &github.Branch{Name: &name, Protected: &protected}
+}
+
+func restResponse(resp *http.Response) *github.Response {
+	if resp == nil {
+		return nil
+	}
+	return &github.Response{Response: resp}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"github.com/google/go-github/github"
+	"net/http"
+	"strings"
+)
+
+type status int
+
+const (
+	statusProtected status = iota
+	statusFreed
+	statusUpdated
+	statusAlreadyProtected
+	statusAlreadyFree
+	statusToProtect
+	statusToUpdate
+	statusToFree
+	statusNoAdmin
+	statusFailed
+	statusUnresolved
+)
+
+var statusNames = map[status]string{
+	statusProtected:        "protected",
+	statusFreed:            "freed",
+	statusUpdated:          "updated",
+	statusAlreadyProtected: "already_protected",
+	statusAlreadyFree:      "already_free",
+	statusToProtect:        "to_protect",
+	statusToUpdate:         "to_update",
+	statusToFree:           "to_free",
+	statusNoAdmin:          "no_admin",
+	statusFailed:           "failed",
+	statusUnresolved:       "unresolved",
+}
+
+func (s status) String() string {
+	return statusNames[s]
+}
+
+func (s status) failed() bool {
+	return s == statusNoAdmin || s == statusFailed || s == statusUnresolved
+}
+
+// compliant tells if the branch is in the state asked by the current run.
+func (s status) compliant() bool {
+	switch s {
+	case statusToProtect, statusToUpdate, statusToFree, statusFailed, statusUnresolved:
+		return false
+	}
+	return true
+}
+
+// result is produced for every branch (or repository when branches can't be inspected) processed by a protection.
+type result struct {
+	repo       *github.Repository
+	branch     string
+	status     status
+	message    string
+	findings   []finding
+	httpStatus int
+}
+
+func newResult(repo *github.Repository, branch string, s status, msg string, findings ...finding) *result {
+	return &result{
+		repo:     repo,
+		branch:   branch,
+		status:   s,
+		message:  fmt.Sprintf("%s: %s %s", *repo.FullName, branch, msg),
+		findings: findings,
+	}
+}
+
+// newUnresolvedResult describes a repository, or an organization, that could not be fetched.
+// Its owner is the part of the name before the first /.
+func newUnresolvedResult(name string, err error) *result {
+	owner := name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		owner = name[:i]
+	}
+	r := &result{
+		repo:       &github.Repository{FullName: &name, Owner: &github.User{Login: &owner}},
+		status:     statusUnresolved,
+		httpStatus: errorStatusCode(err),
+	}
+	if r.httpStatus != 0 {
+		r.message = fmt.Sprintf("%s: can't be fetched (HTTP %d): %v", name, r.httpStatus, err)
+	} else {
+		r.message = fmt.Sprintf("%s: can't be fetched: %v", name, err)
+	}
+	return r
+}
+
+// errorStatusCode returns the HTTP status of an API error, 0 when the error isn't an HTTP response.
+func errorStatusCode(err error) int {
+	var resp *http.Response
+	switch e := err.(type) {
+	case *github.ErrorResponse:
+		resp = e.Response
+	case *github.RateLimitError:
+		resp = e.Response
+	case *github.AbuseRateLimitError:
+		resp = e.Response
+	case *restError:
+		resp = e.resp
+	}
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// protected tells if the branch was protected when it has been inspected.
+func (r *result) protected() bool {
+	switch r.status {
+	case statusAlreadyProtected, statusToUpdate, statusUpdated, statusToFree, statusFreed:
+		return true
+	}
+	return false
+}
+
+func (r *result) org() string {
+	return *r.repo.Owner.Login
+}
+
+type listener interface {
+	notify(r *result)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/go-github/github"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// ruleProtection manages GitHub branch protection rules, they also protect branches created after protector ran.
+type ruleProtection struct {
+	*githubProtection
+	client   *graphqlClient
+	patterns []string
+}
+
+// newRuleProtection converts branch patterns to wildcards, the ones that can't be converted are ignored.
+func newRuleProtection(gp *githubProtection, client *graphqlClient) *ruleProtection {
+	rp := &ruleProtection{githubProtection: gp, client: client}
+	for _, branch := range gp.branchPatterns {
+		wildcard, err := toWildcard(branch.String())
+		if err != nil {
+			fmt.Fprintf(gp.failureOutput, "warning: %v, it is ignored by -rules\n", err)
+			continue
+		}
+		rp.patterns = append(rp.patterns, wildcard)
+	}
+	if (gp.policy != nil && gp.policy.Restrictions != nil) || gp.policies.restricted() {
+		fmt.Fprintln(gp.failureOutput, "warning: push restrictions of the policy are not applied by -rules")
+	}
+	return rp
+}
+
+// policyFor resolves the policy of a repository without its push restrictions, rules can't set them.
+func (rp *ruleProtection) policyFor(repo *github.Repository) *policy {
+	p := rp.githubProtection.policyFor(repo)
+	if p == nil || p.Restrictions == nil {
+		return p
+	}
+	withoutRestrictions := *p
+	withoutRestrictions.Restrictions = nil
+	return &withoutRestrictions
+}
+
+func (rp *ruleProtection) protect(repo *github.Repository) {
+	var p *policy
+	resolved := false
+	rp.processRules(repo, func(repoID string, pattern string, rule *graphqlProtectionRule) *result {
+		if !resolved {
+			p, resolved = rp.policyFor(repo), true
+		}
+		applied, problems, err := rp.withCodeOwners(repo, codeOwnersRef(pattern), p)
+		if err != nil {
+			return newResult(repo, pattern, statusFailed, err.Error())
+		}
+		note := ""
+		if len(problems) > 0 {
+			note = ", code owner reviews can't be required"
+		}
+		if rule == nil {
+			if rp.dryrun {
+				return newResult(repo, pattern, statusToProtect, "rule will be created"+note, append([]finding{{ruleProtected, "no protection rule for " + pattern}}, problems...)...)
+			}
+			if err := rp.createRule(repoID, pattern, applied); err != nil {
+				return newResult(repo, pattern, statusFailed, err.Error(), problems...)
+			}
+			if err := rp.audit.record("create-rule", *repo.FullName, pattern, nil, ruleInput(applied)); err != nil {
+				return newResult(repo, pattern, statusFailed, fmt.Sprintf("rule has been created but can't be audited: %v", err), problems...)
+			}
+			return newResult(repo, pattern, statusProtected, "rule is now created"+note, problems...)
+		}
+
+		findings := applied.check(rule.toGitHub(), rule.settings())
+		if len(findings) == 0 {
+			return newResult(repo, pattern, statusAlreadyProtected, "rule already exists"+note, problems...)
+		}
+		findings = append(findings, problems...)
+		if rp.dryrun {
+			return newResult(repo, pattern, statusToUpdate, "rule will be updated"+note, findings...)
+		}
+		if err := rp.updateRule(rule.ID, applied); err != nil {
+			return newResult(repo, pattern, statusFailed, err.Error(), findings...)
+		}
+		if err := rp.audit.record("update-rule", *repo.FullName, pattern, rule.toGitHub(), ruleInput(applied)); err != nil {
+			return newResult(repo, pattern, statusFailed, fmt.Sprintf("rule has been updated but can't be audited: %v", err), problems...)
+		}
+		return newResult(repo, pattern, statusUpdated, "rule is now up to date"+note, problems...)
+	})
+}
+
+// codeOwnersRef is the branch whose CODEOWNERS file applies to a rule: the branch named by the pattern,
+// or the default branch when the pattern has wildcards.
+func codeOwnersRef(pattern string) string {
+	if strings.ContainsAny(pattern, "*?[") {
+		return ""
+	}
+	return pattern
+}
+
+func (rp *ruleProtection) free(repo *github.Repository) {
+	rp.processRules(repo, func(repoID string, pattern string, rule *graphqlProtectionRule) *result {
+		if rule == nil {
+			return newResult(repo, pattern, statusAlreadyFree, "rule does not exist")
+		}
+		if rp.dryrun {
+			return newResult(repo, pattern, statusToFree, "rule will be deleted")
+		}
+		if err := rp.deleteRule(rule.ID); err != nil {
+			return newResult(repo, pattern, statusFailed, err.Error())
+		}
+		if err := rp.audit.record("delete-rule", *repo.FullName, pattern, rule.toGitHub(), nil); err != nil {
+			return newResult(repo, pattern, statusFailed, fmt.Sprintf("rule has been deleted but can't be audited: %v", err))
+		}
+		return newResult(repo, pattern, statusFreed, "rule is now deleted")
+	})
+}
+
+func (rp *ruleProtection) processRules(repo *github.Repository, modify func(string, string, *graphqlProtectionRule) *result) {
+	if (*repo.Permissions)["admin"] == false {
+		rp.report(&result{
+			repo:    repo,
+			status:  statusNoAdmin,
+			message: fmt.Sprintf("%s: you don't have admin rights to modify this repository", *repo.FullName),
+		})
+		return
+	}
+
+	var data struct {
+		Repository *graphqlRepository `json:"repository"`
+	}
+	query := `query($owner: String!, $name: String!) {
+  repository(owner: $owner, name: $name) {
+    id
+    branchProtectionRules(first: 100) {
+      pageInfo { hasNextPage endCursor }
+      nodes { ...protectionRuleFields }
+    }
+  }
+}` + graphqlProtectionRuleFields
+	variables := map[string]interface{}{"owner": *repo.Owner.Login, "name": *repo.Name}
+	err := rp.client.query(context.TODO(), query, variables, &data)
+	if err == nil {
+		err = rp.client.completeRules(*repo.Owner.Login, *repo.Name, &data.Repository.BranchProtectionRules)
+	}
+	if err != nil {
+		rp.report(&result{repo: repo, status: statusFailed, message: fmt.Sprintf("%s: %v", *repo.FullName, err)})
+		return
+	}
+
+	existing := make(map[string]*graphqlProtectionRule)
+	for _, rule := range data.Repository.BranchProtectionRules.Nodes {
+		existing[rule.Pattern] = rule
+	}
+	for _, pattern := range rp.patterns {
+		if rp.planned != nil && !rp.planned[plannedKey(repo, pattern)] {
+			continue
+		}
+		rp.report(modify(data.Repository.ID, pattern, existing[pattern]))
+	}
+}
+
+func ruleInput(p *policy) map[string]interface{} {
+	input := map[string]interface{}{
+		"requiresStatusChecks":        false,
+		"requiresStrictStatusChecks":  false,
+		"requiredStatusCheckContexts": []string{},
+		"requiresApprovingReviews":    false,
+		"dismissesStaleReviews":       false,
+		"requiresCodeOwnerReviews":    false,
+		"isAdminEnforced":             false,
+		"requiresLinearHistory":       false,
+		"allowsForcePushes":           false,
+		"allowsDeletions":             false,
+		"requiresCommitSignatures":    false,
+	}
+	if p == nil {
+		return input
+	}
+
+	settings := p.settings()
+	input["isAdminEnforced"] = p.EnforceAdmins
+	input["requiresLinearHistory"] = settings.RequiredLinearHistory
+	input["allowsForcePushes"] = settings.AllowForcePushes
+	input["allowsDeletions"] = settings.AllowDeletions
+	input["requiresCommitSignatures"] = settings.RequiredSignatures
+	if checks := p.RequiredStatusChecks; checks != nil {
+		input["requiresStatusChecks"] = true
+		input["requiresStrictStatusChecks"] = checks.Strict
+		input["requiredStatusCheckContexts"] = nonNil(checks.Contexts)
+	}
+	if reviews := p.RequiredPullRequestReviews; reviews != nil {
+		input["requiresApprovingReviews"] = true
+		input["requiredApprovingReviewCount"] = 1
+		if settings.RequiredApprovingReviewCount > 1 {
+			input["requiredApprovingReviewCount"] = settings.RequiredApprovingReviewCount
+		}
+		input["dismissesStaleReviews"] = reviews.DismissStaleReviews
+		input["requiresCodeOwnerReviews"] = settings.RequireCodeOwnerReviews
+	}
+	return input
+}
+
+func (rp *ruleProtection) createRule(repoID, pattern string, p *policy) error {
+	input := ruleInput(p)
+	input["repositoryId"] = repoID
+	input["pattern"] = pattern
+	mutation := `mutation($input: CreateBranchProtectionRuleInput!) {
+  createBranchProtectionRule(input: $input) { branchProtectionRule { id } }
+}`
+	var data interface{}
+	return rp.client.query(context.TODO(), mutation, map[string]interface{}{"input": input}, &data)
+}
+
+func (rp *ruleProtection) updateRule(ruleID string, p *policy) error {
+	input := ruleInput(p)
+	input["branchProtectionRuleId"] = ruleID
+	mutation := `mutation($input: UpdateBranchProtectionRuleInput!) {
+  updateBranchProtectionRule(input: $input) { branchProtectionRule { id } }
+}`
+	var data interface{}
+	return rp.client.query(context.TODO(), mutation, map[string]interface{}{"input": input}, &data)
+}
+
+func (rp *ruleProtection) deleteRule(ruleID string) error {
+	mutation := `mutation($input: DeleteBranchProtectionRuleInput!) {
+  deleteBranchProtectionRule(input: $input) { clientMutationId }
+}`
+	var data interface{}
+	return rp.client.query(context.TODO(), mutation, map[string]interface{}{"input": map[string]interface{}{"branchProtectionRuleId": ruleID}}, &data)
+}
+
+// toWildcard converts a branch regexp to the fnmatch syntax of GitHub protection rules:
+// `*` matches anything but `/`, `**` matches anything and `?` matches one character but `/`.
+func toWildcard(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	re = re.Simplify()
+
+	nodes := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		nodes = re.Sub
+	}
+
+	var wildcard bytes.Buffer
+	anchoredStart, anchoredEnd := false, false
+	for i, node := range nodes {
+		switch {
+		case node.Op == syntax.OpBeginText || node.Op == syntax.OpBeginLine:
+			if i != 0 {
+				return "", fmt.Errorf("%s can't be expressed as a GitHub wildcard", pattern)
+			}
+			anchoredStart = true
+		case node.Op == syntax.OpEndText || node.Op == syntax.OpEndLine:
+			if i != len(nodes)-1 {
+				return "", fmt.Errorf("%s can't be expressed as a GitHub wildcard", pattern)
+			}
+			anchoredEnd = true
+		case node.Op == syntax.OpLiteral && node.Flags&syntax.FoldCase == 0:
+			literal := string(node.Rune)
+			if strings.ContainsAny(literal, `*?[]\{}`) {
+				return "", fmt.Errorf("%s contains characters that can't be used in a GitHub wildcard", pattern)
+			}
+			wildcard.WriteString(literal)
+		case node.Op == syntax.OpStar && isAnyChar(node.Sub[0]):
+			wildcard.WriteString("**")
+		case node.Op == syntax.OpStar && isAnyCharButSlash(node.Sub[0]):
+			wildcard.WriteString("*")
+		case isAnyCharButSlash(node):
+			wildcard.WriteString("?")
+		default:
+			return "", fmt.Errorf("%s can't be expressed as a GitHub wildcard", pattern)
+		}
+	}
+
+	result := wildcard.String()
+	if !anchoredStart && !strings.HasPrefix(result, "**") {
+		result = "**" + result
+	}
+	if !anchoredEnd && !strings.HasSuffix(result, "**") {
+		result += "**"
+	}
+	return result, nil
+}
+
+func isAnyChar(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpAnyChar || re.Op == syntax.OpAnyCharNotNL
+}
+
+// isAnyCharButSlash recognizes [^/], and [^/\n] produced when parsing [^/] with the Perl flags.
+func isAnyCharButSlash(re *syntax.Regexp) bool {
+	if re.Op != syntax.OpCharClass {
+		return false
+	}
+	for _, ranges := range [][]rune{
+		{0, '/' - 1, '/' + 1, unicode.MaxRune},
+		{0, '\n' - 1, '\n' + 1, '/' - 1, '/' + 1, unicode.MaxRune},
+	} {
+		if sameRunes(ranges, re.Rune) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameRunes(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
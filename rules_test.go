@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestToWildcard(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		"^master$":           "master",
+		"^release/.*$":       "release/**",
+		"^release/[^/]*$":    "release/*",
+		"^feature/[^/]$":     "feature/?",
+		"^v1\\.0$":           "v1.0",
+		"develop":            "**develop**",
+		"^hotfix-.*":         "hotfix-**",
+		"^(master|develop)$": "",
+		"^release-[0-9]+$":   "",
+	} {
+		got, err := toWildcard(pattern)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s should not be converted, got: %s", pattern, got)
+			}
+			continue
+		}
+		if err != nil || got != expected {
+			t.Errorf("%s should be converted to %s, got: %s (%v)", pattern, expected, got, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/google/go-github/github"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const sampleSize = 5
+
+// plan processes repositories with a dry-run copy of the protection, without any output. It returns the changes
+// the run would make, and the results that need no change.
+func plan(repos repositories, gp *githubProtection, newProtection func(*githubProtection) protection) (changes, done []*result) {
+	collector := new(reportCollector)
+	planner := *gp
+	planner.successOutput = ioutil.Discard
+	planner.failureOutput = ioutil.Discard
+	planner.listeners = []listener{collector}
+	planner.dryrun = true
+	run(repos, newProtection(&planner))
+
+	changes, done = make([]*result, 0), make([]*result, 0)
+	for _, r := range collector.sorted() {
+		switch r.status {
+		case statusToProtect, statusToUpdate, statusToFree:
+			changes = append(changes, r)
+		default:
+			done = append(done, r)
+		}
+	}
+	return changes, done
+}
+
+// plannedRepositories lists again the repositories of planned changes, so the run changes the planned branches only.
+type plannedRepositories struct {
+	changes []*result
+}
+
+func (pr *plannedRepositories) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, len(pr.changes))
+	seen := make(map[string]bool)
+	for _, r := range pr.changes {
+		if !seen[*r.repo.FullName] {
+			seen[*r.repo.FullName] = true
+			result <- r.repo
+		}
+	}
+	close(result)
+	return result
+}
+
+// plannedBranches indexes the branches, or the rule patterns, of planned changes.
+func plannedBranches(changes []*result) map[string]bool {
+	planned := make(map[string]bool, len(changes))
+	for _, r := range changes {
+		planned[plannedKey(r.repo, r.branch)] = true
+	}
+	return planned
+}
+
+func plannedKey(repo *github.Repository, branch string) string {
+	return *repo.FullName + " " + branch
+}
+
+// describe summarizes changes with their count and a sample of the repositories they touch.
+func describe(changes []*result) string {
+	repos := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, r := range changes {
+		if name := *r.repo.FullName; !seen[name] {
+			seen[name] = true
+			repos = append(repos, name)
+		}
+	}
+	sort.Strings(repos)
+
+	sample := repos
+	if len(sample) > sampleSize {
+		sample = append(sample[:sampleSize:sampleSize], fmt.Sprintf("and %d more", len(repos)-sampleSize))
+	}
+	return fmt.Sprintf("%d branches in %d repositories (%s)", len(changes), len(repos), strings.Join(sample, ", "))
+}
+
+// confirm asks to go on with changes, anything but y or yes refuses.
+func confirm(in io.Reader, out io.Writer, action string, changes []*result) bool {
+	fmt.Fprintf(out, "%s %s, continue? [y/N] ", action, describe(changes))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
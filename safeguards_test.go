@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/go-github/github"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type TestPlannedRepositories struct {
+	names []string
+}
+
+func (r *TestPlannedRepositories) fetch(unresolved func(*result)) chan *github.Repository {
+	result := make(chan *github.Repository, len(r.names))
+	login := "jcgay"
+	for _, name := range r.names {
+		repoName, fullName := name, login+"/"+name
+		result <- &github.Repository{Name: &repoName, FullName: &fullName, Owner: &github.User{Login: &login}, Permissions: &map[string]bool{"admin": true}}
+	}
+	close(result)
+	return result
+}
+
+func TestPlanListsChangesWithoutMakingThem(t *testing.T) {
+	// Given
+	success := new(bytes.Buffer)
+	mock := &TestLeaseMock{protected: map[string]bool{}}
+	gp := &githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       success,
+		failureOutput:       success,
+	}
+	repos := &TestPlannedRepositories{names: []string{"a", "b", "c", "d", "e", "f", "g"}}
+
+	// When
+	changes, done := plan(repos, gp, func(gp *githubProtection) protection { return gp })
+
+	// Then
+	if mock.restored != nil || success.Len() > 0 || gp.dryrun {
+		t.Error("Planning should not change or print anything")
+	}
+	if len(done) != 0 {
+		t.Errorf("Every branch should be changed, got: %v", done)
+	}
+	if description := describe(changes); description != "7 branches in 7 repositories (jcgay/a, jcgay/b, jcgay/c, jcgay/d, jcgay/e, and 2 more)" {
+		t.Errorf("Unexpected description of the changes: %s", description)
+	}
+}
+
+func TestConfirmOnlyAcceptsYes(t *testing.T) {
+	for answer, expected := range map[string]bool{"y\n": true, "yes\n": true, "\n": false, "n\n": false, "": false} {
+		// Given
+		out := new(bytes.Buffer)
+		repoName, login, fullName := "maven-color", "jcgay", "jcgay/maven-color"
+		repo := &github.Repository{Name: &repoName, FullName: &fullName, Owner: &github.User{Login: &login}}
+		changes := []*result{newResult(repo, "master", statusToFree, "will be freed")}
+
+		// When
+		confirmed := confirm(strings.NewReader(answer), out, "Freeing", changes)
+
+		// Then
+		if confirmed != expected {
+			t.Errorf("Answer %q should confirm: %v", answer, expected)
+		}
+		if !strings.HasPrefix(out.String(), "Freeing 1 branches in 1 repositories (jcgay/maven-color), continue? [y/N]") {
+			t.Errorf("Unexpected prompt: %s", out.String())
+		}
+	}
+}
+
+func TestRunOnlyChangesPlannedBranches(t *testing.T) {
+	// Given
+	success := new(bytes.Buffer)
+	mock := &TestLeaseMock{protected: map[string]bool{}}
+	gp := &githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       success,
+		failureOutput:       success,
+	}
+	changes, _ := plan(&TestPlannedRepositories{names: []string{"a", "b"}}, gp, func(gp *githubProtection) protection { return gp })
+
+	// When
+	gp.planned = plannedBranches(changes[:1])
+	run(&plannedRepositories{changes: changes}, gp)
+
+	// Then
+	if success.String() != "jcgay/a: branche-1 is now protected\n" {
+		t.Errorf("Only the planned branch should be protected, got: [%s]", success.String())
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/github"
+)
+
+// protectionSettings are the settings of a branch protection that the vendored client types miss.
+type protectionSettings struct {
+	RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+	RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+	RequiredLinearHistory        bool `json:"required_linear_history"`
+	AllowForcePushes             bool `json:"allow_force_pushes"`
+	AllowDeletions               bool `json:"allow_deletions"`
+	RequiredSignatures           bool `json:"required_signatures"`
+}
+
+// settingsService reads and sets branch protections with their settings. Services that don't implement it only use
+// the vendored types, and the settings of the policy are then ignored.
+type settingsService interface {
+	GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error)
+	UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error)
+}
+
+// githubOnlySettings lists the settings of a policy that only GitHub enforces, except the ones the host supports.
+func githubOnlySettings(p *policy, supported ...string) []string {
+	if p == nil {
+		return nil
+	}
+
+	settings := make([]string, 0)
+	if reviews := p.RequiredPullRequestReviews; reviews != nil && reviews.RequireCodeOwnerReviews {
+		settings = append(settings, "require_code_owner_reviews")
+	}
+	if reviews := p.RequiredPullRequestReviews; reviews != nil && reviews.RequiredApprovingReviewCount > 0 {
+		settings = append(settings, "required_approving_review_count")
+	}
+	if p.RequiredLinearHistory {
+		settings = append(settings, "required_linear_history")
+	}
+	if p.AllowForcePushes != nil {
+		settings = append(settings, "allow_force_pushes")
+	}
+	if p.AllowDeletions != nil {
+		settings = append(settings, "allow_deletions")
+	}
+	if p.RequiredSignatures {
+		settings = append(settings, "required_signatures")
+	}
+
+	warnings := make([]string, 0, len(settings))
+	for _, setting := range missing(settings, supported) {
+		warnings = append(warnings, setting+": only GitHub enforces it")
+	}
+	return warnings
+}
+
+// getProtection reads the protection of a branch, with its settings when the service supports them.
+func (gp *githubProtection) getProtection(repo *github.Repository, branchName string) (*github.Protection, *protectionSettings, error) {
+	if service, ok := gp.repositoriesService.(settingsService); ok {
+		protection, settings, _, err := service.GetBranchProtectionSettings(context.TODO(), *repo.Owner.Login, *repo.Name, branchName)
+		return protection, settings, err
+	}
+	protection, _, err := gp.repositoriesService.GetBranchProtection(context.TODO(), *repo.Owner.Login, *repo.Name, branchName)
+	return protection, nil, err
+}
+
+// updateProtection sets the protection of a branch, with its settings when the service supports them.
+func (gp *githubProtection) updateProtection(repo *github.Repository, branchName string, preq *github.ProtectionRequest, settings *protectionSettings) error {
+	if service, ok := gp.repositoriesService.(settingsService); ok && settings != nil {
+		_, _, err := service.UpdateBranchProtectionSettings(context.TODO(), *repo.Owner.Login, *repo.Name, branchName, preq, settings)
+		return err
+	}
+	_, _, err := gp.repositoriesService.UpdateBranchProtection(context.TODO(), *repo.Owner.Login, *repo.Name, branchName, preq)
+	return err
+}
+
+const mediaTypeProtectionSettingsPreview = "application/vnd.github.luke-cage-preview+json, application/vnd.github.zzzax-preview+json"
+
+// githubRepositoriesService sends and reads the settings missing from the vendored types with hand made requests.
+type githubRepositoriesService struct {
+	*github.RepositoriesService
+	client *github.Client
+}
+
+type enabledSetting struct {
+	Enabled bool `json:"enabled"`
+}
+
+type protectionSettingsResponse struct {
+	RequiredPullRequestReviews *struct {
+		RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+		RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+	} `json:"required_pull_request_reviews"`
+	RequiredLinearHistory enabledSetting `json:"required_linear_history"`
+	AllowForcePushes      enabledSetting `json:"allow_force_pushes"`
+	AllowDeletions        enabledSetting `json:"allow_deletions"`
+	RequiredSignatures    enabledSetting `json:"required_signatures"`
+}
+
+func (sr *protectionSettingsResponse) settings() *protectionSettings {
+	settings := &protectionSettings{
+		RequiredLinearHistory: sr.RequiredLinearHistory.Enabled,
+		AllowForcePushes:      sr.AllowForcePushes.Enabled,
+		AllowDeletions:        sr.AllowDeletions.Enabled,
+		RequiredSignatures:    sr.RequiredSignatures.Enabled,
+	}
+	if reviews := sr.RequiredPullRequestReviews; reviews != nil {
+		settings.RequiredApprovingReviewCount = reviews.RequiredApprovingReviewCount
+		settings.RequireCodeOwnerReviews = reviews.RequireCodeOwnerReviews
+	}
+	return settings
+}
+
+func (s *githubRepositoriesService) GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error) {
+	req, err := s.client.NewRequest("GET", fmt.Sprintf("repos/%v/%v/branches/%v/protection", owner, repo, branch), nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req.Header.Set("Accept", mediaTypeProtectionSettingsPreview)
+
+	var body json.RawMessage
+	resp, err := s.client.Do(ctx, req, &body)
+	if err != nil {
+		return nil, nil, resp, err
+	}
+	protection := new(github.Protection)
+	if err := json.Unmarshal(body, protection); err != nil {
+		return nil, nil, resp, err
+	}
+	var settings protectionSettingsResponse
+	if err := json.Unmarshal(body, &settings); err != nil {
+		return nil, nil, resp, err
+	}
+	return protection, settings.settings(), resp, nil
+}
+
+// UpdateBranchProtectionSettings adds the settings to the request built by the vendored types,
+// signed commits are then required with their own endpoint. They are never turned off, as the policy is a floor.
+func (s *githubRepositoriesService) UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error) {
+	body, err := settingsRequest(preq, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	u := fmt.Sprintf("repos/%v/%v/branches/%v/protection", owner, repo, branch)
+	req, err := s.client.NewRequest("PUT", u, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", mediaTypeProtectionSettingsPreview)
+	protection := new(github.Protection)
+	resp, err := s.client.Do(ctx, req, protection)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	if !settings.RequiredSignatures {
+		return protection, resp, nil
+	}
+	req, err = s.client.NewRequest("POST", u+"/required_signatures", nil)
+	if err != nil {
+		return protection, resp, &signaturesError{err}
+	}
+	req.Header.Set("Accept", mediaTypeProtectionSettingsPreview)
+	if resp, err = s.client.Do(ctx, req, nil); err != nil {
+		return protection, resp, &signaturesError{err}
+	}
+	return protection, resp, nil
+}
+
+// signaturesError reports a protection that has been updated but whose signed commits could not be required.
+type signaturesError struct {
+	err error
+}
+
+func (e *signaturesError) Error() string {
+	return fmt.Sprintf("protection has been updated but signed commits can't be required: %v", e.err)
+}
+
+// settingsRequest builds the body of a protection request with the settings the vendored request can't hold.
+func settingsRequest(preq *github.ProtectionRequest, settings *protectionSettings) (map[string]interface{}, error) {
+	content, err := json.Marshal(preq)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(content, &body); err != nil {
+		return nil, err
+	}
+
+	if reviews, ok := body["required_pull_request_reviews"].(map[string]interface{}); ok {
+		reviews["require_code_owner_reviews"] = settings.RequireCodeOwnerReviews
+		if settings.RequiredApprovingReviewCount > 0 {
+			reviews["required_approving_review_count"] = settings.RequiredApprovingReviewCount
+		}
+	}
+	body["required_linear_history"] = settings.RequiredLinearHistory
+	body["allow_force_pushes"] = settings.AllowForcePushes
+	body["allow_deletions"] = settings.AllowDeletions
+	return body, nil
+}
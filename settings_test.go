@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/google/go-github/github"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+type TestSettingsMock struct {
+	TestLeaseMock
+	current  *protectionSettings
+	request  *github.ProtectionRequest
+	settings *protectionSettings
+}
+
+func (p *TestSettingsMock) GetBranchProtectionSettings(ctx context.Context, owner, repo, branch string) (*github.Protection, *protectionSettings, *github.Response, error) {
+	protection, resp, err := p.GetBranchProtection(ctx, owner, repo, branch)
+	protection.RequiredPullRequestReviews = &github.PullRequestReviewsEnforcement{}
+	return protection, p.current, resp, err
+}
+
+func (p *TestSettingsMock) UpdateBranchProtectionSettings(ctx context.Context, owner, repo, branch string, preq *github.ProtectionRequest, settings *protectionSettings) (*github.Protection, *github.Response, error) {
+	p.request, p.settings = preq, settings
+	return nil, nil, nil
+}
+
+func TestProtectUpdatesSettingsMissingFromVendoredTypes(t *testing.T) {
+	// Given
+	allowed, blocked := true, false
+	mock := &TestSettingsMock{
+		TestLeaseMock: TestLeaseMock{protected: map[string]bool{"branche-1": true}},
+		current:       &protectionSettings{RequiredApprovingReviewCount: 1, AllowForcePushes: true, AllowDeletions: true},
+	}
+	collector := new(reportCollector)
+	gp := &githubProtection{
+		repositoriesService: mock,
+		branchPatterns:      []*regexp.Regexp{regexp.MustCompile("^branch")},
+		successOutput:       new(bytes.Buffer),
+		failureOutput:       new(bytes.Buffer),
+		listeners:           []listener{collector},
+		policy: &policy{
+			RequiredPullRequestReviews: &reviewsPolicy{RequiredApprovingReviewCount: 2},
+			RequiredLinearHistory:      true,
+			AllowForcePushes:           &blocked,
+			AllowDeletions:             &allowed,
+			RequiredSignatures:         true,
+		},
+	}
+	name, login, fullName := "maven-color", "jcgay", "jcgay/maven-color"
+	repo := &github.Repository{Name: &name, FullName: &fullName, Owner: &github.User{Login: &login}, Permissions: &map[string]bool{"admin": true}}
+
+	// When
+	_, _, findings, err := gp.inspect(repo, "branche-1", gp.policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gp.protect(repo)
+
+	// Then
+	expected := []finding{
+		{ruleApprovingReviews, "1 approving reviews are required instead of 2"},
+		{ruleLinearHistory, "linear history is not required"},
+		{ruleForcePushes, "force pushes are allowed"},
+		{ruleSignatures, "signed commits are not required"},
+	}
+	if !reflect.DeepEqual(findings, expected) {
+		t.Errorf("Unexpected findings %+v", findings)
+	}
+	if results := collector.sorted(); len(results) != 1 || results[0].status != statusUpdated {
+		t.Errorf("Branch should be updated, got %+v", results)
+	}
+	if !reflect.DeepEqual(mock.settings, &protectionSettings{RequiredApprovingReviewCount: 2, RequiredLinearHistory: true, AllowDeletions: true, RequiredSignatures: true}) {
+		t.Errorf("Settings of the policy should be sent, got %+v", mock.settings)
+	}
+}
+
+func TestGitHubServiceSendsAndReadsSettings(t *testing.T) {
+	// Given
+	requests := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests[r.Method+" "+r.URL.Path] = string(body)
+		if r.Method == "GET" {
+			w.Write([]byte(`{
+  "required_pull_request_reviews": {"dismiss_stale_reviews": true, "require_code_owner_reviews": true, "required_approving_review_count": 2},
+  "enforce_admins": {"enabled": true},
+  "required_linear_history": {"enabled": true},
+  "allow_force_pushes": {"enabled": false},
+  "allow_deletions": {"enabled": true},
+  "required_signatures": {"enabled": true}
+}`))
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	service := &githubRepositoriesService{client.Repositories, client}
+
+	// When
+	protection, settings, _, err := service.GetBranchProtectionSettings(context.TODO(), "jcgay", "maven-color", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	preq := &github.ProtectionRequest{RequiredPullRequestReviews: &github.PullRequestReviewsEnforcementRequest{DismissStaleReviews: true}}
+	if _, _, err := service.UpdateBranchProtectionSettings(context.TODO(), "jcgay", "maven-color", "master", preq, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Then
+	if !protection.EnforceAdmins.Enabled || !protection.RequiredPullRequestReviews.DismissStaleReviews {
+		t.Errorf("Protection should be read, got %+v", protection)
+	}
+	expected := &protectionSettings{RequiredApprovingReviewCount: 2, RequireCodeOwnerReviews: true, RequiredLinearHistory: true, AllowDeletions: true, RequiredSignatures: true}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Errorf("Settings should be read, got %+v", settings)
+	}
+
+	var sent map[string]interface{}
+	if err := json.Unmarshal([]byte(requests["PUT /repos/jcgay/maven-color/branches/master/protection"]), &sent); err != nil {
+		t.Fatal(err)
+	}
+	reviews := sent["required_pull_request_reviews"].(map[string]interface{})
+	if reviews["required_approving_review_count"] != 2.0 || reviews["require_code_owner_reviews"] != true || reviews["dismiss_stale_reviews"] != true {
+		t.Errorf("Reviews settings should be sent, got %v", reviews)
+	}
+	if sent["required_linear_history"] != true || sent["allow_force_pushes"] != false || sent["allow_deletions"] != true {
+		t.Errorf("Settings should be sent, got %v", sent)
+	}
+	if _, ok := requests["POST /repos/jcgay/maven-color/branches/master/protection/required_signatures"]; !ok {
+		t.Errorf("Signed commits should be required with their endpoint, got %v", requests)
+	}
+}
+
+func TestProtectionUpdatedWithoutSignaturesIsAudited(t *testing.T) {
+	// Given
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			http.Error(w, `{"message": "Forbidden"}`, http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "protector-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	path := filepath.Join(dir, "audit.jsonl")
+	service := newAuditedService(&githubRepositoriesService{client.Repositories, client}, &auditLog{path: path}).(settingsService)
+
+	// When
+	_, _, err = service.UpdateBranchProtectionSettings(context.TODO(), "jcgay", "maven-color", "master", &github.ProtectionRequest{}, &protectionSettings{RequiredSignatures: true})
+
+	// Then
+	if _, ok := err.(*signaturesError); !ok {
+		t.Errorf("Missing signatures should be reported, got %v", err)
+	}
+	content, _ := ioutil.ReadFile(path)
+	var record struct {
+		After protectionState `json:"after"`
+	}
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("Protection update should be audited, got [%s]: %v", content, err)
+	}
+	if record.After.Settings == nil || record.After.Settings.RequiredSignatures {
+		t.Errorf("Audited settings should not require signatures, got %+v", record.After.Settings)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// summary counts results by status and API calls made during a run.
+type summary struct {
+	apiCalls int64 // first field to be 64-bit aligned for atomic operations on 32-bit platforms
+	mu       sync.Mutex
+	statuses map[status]int
+}
+
+func newSummary() *summary {
+	return &summary{statuses: make(map[status]int)}
+}
+
+func (s *summary) notify(r *result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[r.status]++
+}
+
+func (s *summary) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = make(map[status]int)
+	atomic.StoreInt64(&s.apiCalls, 0)
+}
+
+// unresolved counts the repositories and organizations that could not be fetched.
+func (s *summary) unresolved() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses[statusUnresolved]
+}
+
+func (s *summary) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make([]string, 0)
+	for st := statusProtected; st <= statusUnresolved; st++ {
+		if count := s.statuses[st]; count > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", count, strings.Replace(st.String(), "_", " ", -1)))
+		}
+	}
+	if len(counts) == 0 {
+		counts = append(counts, "no branch processed")
+	}
+	fmt.Fprintf(w, "summary: %s, %d API calls\n", strings.Join(counts, ", "), atomic.LoadInt64(&s.apiCalls))
+}
+
+// countingTransport counts the requests really sent to the API.
+type countingTransport struct {
+	base    http.RoundTripper
+	summary *summary
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.summary.apiCalls, 1)
+	return t.base.RoundTrip(req)
+}